@@ -0,0 +1,170 @@
+// Package api serves a node's DAG over HTTP.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/p2p"
+	"github.com/Abdullah-zahoor/dagchain/viz"
+)
+
+// Server answers queries about DAG. Finality and Node may be nil.
+type Server struct {
+	DAG      *dag.DAG
+	Finality *consensus.Finality
+	Node     *p2p.Node
+}
+
+// HeaderInfo is a block header with its ID, as served to light clients.
+type HeaderInfo struct {
+	ID string
+	block.Header
+}
+
+// TipInfo summarizes a tip.
+type TipInfo struct {
+	ID        string
+	Height    uint64
+	BlueScore uint64
+	Weight    uint64
+}
+
+// TxProof proves a transaction is in a block. A client holding the
+// block's header checks it with block.VerifyTxProof against TxRoot.
+type TxProof struct {
+	BlockID string
+	TxRoot  string
+	Tx      block.TX
+	Proof   *block.MerkleProof
+}
+
+// UTXOProof proves an outpoint is or is not in the UTXO set a block
+// leaves behind. UTXORoot is the header's when the block commits to one; a
+// client checks the proof with block.VerifyUTXOProof.
+type UTXOProof struct {
+	BlockID  string
+	UTXORoot string
+	Proof    *block.UTXOProof
+}
+
+// Handler routes:
+//
+//	GET /tips                             heaviest tip
+//	GET /finalized                        finalized block IDs in order
+//	GET /headers                          every header in consensus order
+//	GET /blocks/{id}/header               one header
+//	GET /blocks/{id}/txs/{txid}/proof     Merkle proof for a transaction
+//	GET /blocks/{id}/utxos/{txid}/{index} UTXO set (non-)membership proof
+//	GET /sync                             progress syncing with peers
+//	GET /ascii, /dot                      DAG renderings
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tips", s.tips)
+	mux.HandleFunc("GET /finalized", s.finalized)
+	mux.HandleFunc("GET /headers", s.headers)
+	mux.HandleFunc("GET /blocks/{id}/header", s.header)
+	mux.HandleFunc("GET /blocks/{id}/txs/{txid}/proof", s.txProof)
+	mux.HandleFunc("GET /blocks/{id}/utxos/{txid}/{index}", s.utxoProof)
+	mux.HandleFunc("GET /sync", s.sync)
+	mux.HandleFunc("GET /ascii", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, viz.ASCII(s.DAG))
+	})
+	mux.HandleFunc("GET /dot", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, viz.DOT(s.DAG))
+	})
+	return mux
+}
+
+func (s *Server) tips(w http.ResponseWriter, r *http.Request) {
+	tip := s.DAG.HeaviestTip()
+	if tip == nil {
+		http.Error(w, "empty DAG", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, TipInfo{ID: tip.Block.ID, Height: tip.Height, BlueScore: tip.BlueScore, Weight: tip.Weight})
+}
+
+func (s *Server) finalized(w http.ResponseWriter, r *http.Request) {
+	ids := []string{}
+	if s.Finality != nil {
+		ids = s.Finality.Finalized()
+	}
+	writeJSON(w, ids)
+}
+
+func (s *Server) headers(w http.ResponseWriter, r *http.Request) {
+	order := consensus.TotalOrder(s.DAG)
+	out := make([]HeaderInfo, len(order))
+	for i, n := range order {
+		out[i] = HeaderInfo{ID: n.Block.ID, Header: n.Block.Header}
+	}
+	writeJSON(w, out)
+}
+
+func (s *Server) header(w http.ResponseWriter, r *http.Request) {
+	n := s.DAG.Node(r.PathValue("id"))
+	if n == nil {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, HeaderInfo{ID: n.Block.ID, Header: n.Block.Header})
+}
+
+func (s *Server) txProof(w http.ResponseWriter, r *http.Request) {
+	n := s.DAG.Node(r.PathValue("id"))
+	if n == nil {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+	blk := n.Block
+	proof, err := blk.ProveTx(r.PathValue("txid"))
+	if errors.Is(err, block.ErrTxNotInBlock) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, TxProof{BlockID: blk.ID, TxRoot: blk.TxRoot, Tx: blk.TXs[proof.Index], Proof: proof})
+}
+
+func (s *Server) utxoProof(w http.ResponseWriter, r *http.Request) {
+	n := s.DAG.Node(r.PathValue("id"))
+	if n == nil {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+	idx, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || idx < 0 {
+		http.Error(w, "bad output index", http.StatusBadRequest)
+		return
+	}
+	if n.UTXO == nil {
+		http.Error(w, "block state not kept", http.StatusGone)
+		return
+	}
+	key := block.UTXOKey{TxID: r.PathValue("txid"), OutIndex: idx}
+	writeJSON(w, UTXOProof{BlockID: n.Block.ID, UTXORoot: n.UTXO.Root(), Proof: n.UTXO.Prove(key)})
+}
+
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	if s.Node == nil {
+		http.Error(w, "not connected to a network", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.Node.SyncStatus())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
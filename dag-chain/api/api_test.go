@@ -0,0 +1,97 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/api"
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/p2p"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestHeadersAndProofs(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, nil)
+	blk := block.NewBlock([]string{gen.ID}, []block.TX{cb}, ts.Add(time.Second))
+	if err := d.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer((&api.Server{DAG: d}).Handler())
+	defer srv.Close()
+
+	var headers []api.HeaderInfo
+	if code := get(t, srv, "/headers", &headers); code != http.StatusOK {
+		t.Fatalf("/headers: status %d", code)
+	}
+	if len(headers) != 2 || headers[0].ID != gen.ID || headers[1].ID != blk.ID {
+		t.Fatalf("headers out of order: %+v", headers)
+	}
+	// a light client checks the header against its ID
+	if h := headers[1].Header; h.Hash() != blk.ID {
+		t.Fatal("served header does not hash to the block ID")
+	}
+
+	var p api.TxProof
+	if code := get(t, srv, "/blocks/"+blk.ID+"/txs/"+cb.ID+"/proof", &p); code != http.StatusOK {
+		t.Fatalf("proof: status %d", code)
+	}
+	if !block.VerifyTxProof(&p.Tx, p.Proof, headers[1].TxRoot) {
+		t.Error("served proof does not verify against the served header")
+	}
+
+	for _, tc := range []struct {
+		txid string
+		want bool
+	}{{cb.ID, true}, {"nope", false}} {
+		var u api.UTXOProof
+		if code := get(t, srv, "/blocks/"+blk.ID+"/utxos/"+tc.txid+"/0", &u); code != http.StatusOK {
+			t.Fatalf("utxo proof: status %d", code)
+		}
+		if _, ok, err := block.VerifyUTXOProof(u.Proof, u.UTXORoot); err != nil || ok != tc.want {
+			t.Errorf("utxo proof for %s: %v %v, want %v", tc.txid, ok, err, tc.want)
+		}
+	}
+
+	if code := get(t, srv, "/blocks/"+blk.ID+"/txs/nope/proof", nil); code != http.StatusNotFound {
+		t.Errorf("unknown tx: status %d", code)
+	}
+	if code := get(t, srv, "/blocks/nope/header", nil); code != http.StatusNotFound {
+		t.Errorf("unknown block: status %d", code)
+	}
+
+	if code := get(t, srv, "/sync", nil); code != http.StatusNotFound {
+		t.Errorf("sync without a node: status %d", code)
+	}
+	node := p2p.New(d, nil, p2p.Config{})
+	defer node.Close()
+	netSrv := httptest.NewServer((&api.Server{DAG: d, Node: node}).Handler())
+	defer netSrv.Close()
+	var st p2p.SyncStatus
+	if code := get(t, netSrv, "/sync", &st); code != http.StatusOK || st.State != p2p.SyncIdle {
+		t.Errorf("/sync: status %d, state %v", code, st.State)
+	}
+}
@@ -0,0 +1,46 @@
+package block
+
+import "math/bits"
+
+// Subsidy schedule: every block may mint InitialSubsidy, halving every
+// HalvingInterval blocks of height.
+const (
+	InitialSubsidy  uint64 = 50
+	HalvingInterval uint64 = 210_000
+)
+
+// Subsidy returns the amount a coinbase at height may mint on top of fees.
+func Subsidy(height uint64) uint64 {
+	halvings := height / HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return InitialSubsidy >> halvings
+}
+
+// IsCoinbase reports whether tx mints value, i.e. spends no inputs. Only
+// the first transaction of a block may be a coinbase.
+func (tx *TX) IsCoinbase() bool {
+	return len(tx.Inputs) == 0
+}
+
+// addValue returns a+b or ErrValueOverflow.
+func addValue(a, b uint64) (uint64, error) {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return 0, ErrValueOverflow
+	}
+	return sum, nil
+}
+
+// outputSum totals tx's outputs without overflowing.
+func (tx *TX) outputSum() (uint64, error) {
+	var total uint64
+	for _, out := range tx.Outputs {
+		var err error
+		if total, err = addValue(total, out.Value); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
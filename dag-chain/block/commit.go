@@ -0,0 +1,313 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+)
+
+// The UTXO commitment is a sparse Merkle tree over the SHA-256 of each
+// outpoint, one bit per level, MSB first. An empty subtree hashes to
+// zero and a subtree holding a single entry is that entry's leaf, at
+// whatever depth it ends up, so the root depends only on the set's
+// contents and a tree of n entries is about log2(n) deep. Leaves and inner
+// nodes are hashed under the same prefixes as the tx Merkle tree.
+
+// CommittedStore is a UTXOStore that keeps a commitment to its contents
+// in step with the store it wraps. Every write costs a hash per level of
+// the tree; Snapshot shares the tree like a UTXOTrie does.
+type CommittedStore struct {
+	store UTXOStore
+	root  *smtNode // immutable; writes copy the path they change
+}
+
+var _ UTXOStore = (*CommittedStore)(nil)
+
+type smtNode struct {
+	hash        [32]byte
+	left, right *smtNode // both nil for a leaf
+
+	// leaf only
+	keyHash [32]byte
+	key     UTXOKey
+	out     TXOutput
+}
+
+func (n *smtNode) leaf() bool { return n.left == nil && n.right == nil }
+
+func (n *smtNode) sum() [32]byte {
+	if n == nil {
+		return [32]byte{}
+	}
+	return n.hash
+}
+
+func utxoKeyHash(key UTXOKey) [32]byte {
+	var e encoder
+	e.string(key.TxID)
+	e.uint64(uint64(key.OutIndex))
+	return sha256.Sum256(e.buf.Bytes())
+}
+
+func utxoLeafHash(keyHash [32]byte, out TXOutput) [32]byte {
+	var e encoder
+	e.uint64(out.Value)
+	e.string(out.Recipient)
+	value := sha256.Sum256(e.buf.Bytes())
+	buf := make([]byte, 0, 65)
+	buf = append(buf, leafPrefix)
+	buf = append(buf, keyHash[:]...)
+	buf = append(buf, value[:]...)
+	return sha256.Sum256(buf)
+}
+
+func newSMTLeaf(key UTXOKey, out TXOutput) *smtNode {
+	n := &smtNode{keyHash: utxoKeyHash(key), key: key, out: out}
+	n.hash = utxoLeafHash(n.keyHash, out)
+	return n
+}
+
+// newSMTBranch joins two subtrees, at least one of them non-empty.
+func newSMTBranch(left, right *smtNode) *smtNode {
+	return &smtNode{left: left, right: right, hash: nodeHash(left.sum(), right.sum())}
+}
+
+// bit returns bit depth of h, counting from the most significant.
+func bit(h [32]byte, depth int) byte {
+	return h[depth/8] >> (7 - depth%8) & 1
+}
+
+func smtInsert(n *smtNode, depth int, lf *smtNode) *smtNode {
+	switch {
+	case n == nil:
+		return lf
+	case n.leaf() && n.keyHash == lf.keyHash:
+		return lf
+	case n.leaf():
+		return smtSplit(n, lf, depth)
+	case bit(lf.keyHash, depth) == 0:
+		return newSMTBranch(smtInsert(n.left, depth+1, lf), n.right)
+	default:
+		return newSMTBranch(n.left, smtInsert(n.right, depth+1, lf))
+	}
+}
+
+// smtSplit builds the smallest subtree holding two distinct leaves.
+func smtSplit(a, b *smtNode, depth int) *smtNode {
+	ba, bb := bit(a.keyHash, depth), bit(b.keyHash, depth)
+	switch {
+	case ba == bb && ba == 0:
+		return newSMTBranch(smtSplit(a, b, depth+1), nil)
+	case ba == bb:
+		return newSMTBranch(nil, smtSplit(a, b, depth+1))
+	case ba == 0:
+		return newSMTBranch(a, b)
+	default:
+		return newSMTBranch(b, a)
+	}
+}
+
+func smtDelete(n *smtNode, depth int, keyHash [32]byte) *smtNode {
+	if n == nil {
+		return nil
+	}
+	if n.leaf() {
+		if n.keyHash == keyHash {
+			return nil
+		}
+		return n
+	}
+	left, right := n.left, n.right
+	if bit(keyHash, depth) == 0 {
+		left = smtDelete(left, depth+1, keyHash)
+		if left == n.left {
+			return n
+		}
+	} else {
+		right = smtDelete(right, depth+1, keyHash)
+		if right == n.right {
+			return n
+		}
+	}
+	// A branch left holding a single leaf collapses into it.
+	switch {
+	case left == nil && (right == nil || right.leaf()):
+		return right
+	case right == nil && left.leaf():
+		return left
+	}
+	return newSMTBranch(left, right)
+}
+
+// smtBuild builds the tree over leaves sorted by key hash, all of which
+// agree on the bits above depth.
+func smtBuild(leaves []*smtNode, depth int) *smtNode {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		return leaves[0]
+	}
+	split := sort.Search(len(leaves), func(i int) bool { return bit(leaves[i].keyHash, depth) == 1 })
+	return newSMTBranch(smtBuild(leaves[:split], depth+1), smtBuild(leaves[split:], depth+1))
+}
+
+// Commit wraps s, taking ownership of it, and commits to its current
+// contents.
+func Commit(s UTXOStore) (*CommittedStore, error) {
+	var leaves []*smtNode
+	if err := s.Iterate(func(k UTXOKey, out TXOutput) bool {
+		leaves = append(leaves, newSMTLeaf(k, out))
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return string(leaves[i].keyHash[:]) < string(leaves[j].keyHash[:])
+	})
+	return &CommittedStore{store: s, root: smtBuild(leaves, 0)}, nil
+}
+
+// Root returns the hex commitment to the store's contents, the value a
+// block's UTXORoot carries. Any two stores with the same contents have the
+// same root.
+func (c *CommittedStore) Root() string {
+	h := c.root.sum()
+	return hex.EncodeToString(h[:])
+}
+
+func (c *CommittedStore) Get(key UTXOKey) (TXOutput, bool, error) {
+	return c.store.Get(key)
+}
+
+func (c *CommittedStore) Put(key UTXOKey, out TXOutput) error {
+	if err := c.store.Put(key, out); err != nil {
+		return err
+	}
+	c.root = smtInsert(c.root, 0, newSMTLeaf(key, out))
+	return nil
+}
+
+func (c *CommittedStore) Delete(key UTXOKey) error {
+	if err := c.store.Delete(key); err != nil {
+		return err
+	}
+	c.root = smtDelete(c.root, 0, utxoKeyHash(key))
+	return nil
+}
+
+func (c *CommittedStore) Iterate(fn func(UTXOKey, TXOutput) bool) error {
+	return c.store.Iterate(fn)
+}
+
+func (c *CommittedStore) Snapshot() (UTXOStore, error) {
+	return c.Fork()
+}
+
+// Fork is Snapshot with the concrete type.
+func (c *CommittedStore) Fork() (*CommittedStore, error) {
+	s, err := c.store.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &CommittedStore{store: s, root: c.root}, nil
+}
+
+// UTXOProof shows whether Key is in the set committed to by a root. It
+// follows Key's path down the tree, collecting the sibling of each node on
+// the way, top first, until the path ends:
+//
+//   - at Key's own leaf: Output is the entry;
+//   - at an empty subtree: Key is absent;
+//   - at the leaf of another key sharing the path: Key is absent, and
+//     OtherKey and OtherOutput name that leaf.
+type UTXOProof struct {
+	Key         UTXOKey
+	Output      *TXOutput // nil when Key is absent
+	OtherKey    *UTXOKey
+	OtherOutput *TXOutput
+	Siblings    []string // hex hashes
+}
+
+// Prove returns a membership proof for key if the store holds it and a
+// non-membership proof otherwise, both against Root.
+func (c *CommittedStore) Prove(key UTXOKey) *UTXOProof {
+	proof := &UTXOProof{Key: key}
+	keyHash := utxoKeyHash(key)
+	n := c.root
+	for depth := 0; n != nil && !n.leaf(); depth++ {
+		next, sib := n.left, n.right
+		if bit(keyHash, depth) == 1 {
+			next, sib = n.right, n.left
+		}
+		h := sib.sum()
+		proof.Siblings = append(proof.Siblings, hex.EncodeToString(h[:]))
+		n = next
+	}
+	switch {
+	case n == nil:
+	case n.keyHash == keyHash:
+		out := n.out
+		proof.Output = &out
+	default:
+		other, out := n.key, n.out
+		proof.OtherKey, proof.OtherOutput = &other, &out
+	}
+	return proof
+}
+
+// ErrBadUTXOProof is returned by VerifyUTXOProof for a proof that does not
+// hash up to the root.
+var ErrBadUTXOProof = errors.New("utxo proof does not match root")
+
+// VerifyUTXOProof checks proof against a UTXORoot and returns the output
+// it proves for proof.Key, or false if it proves the key absent. It needs
+// only the root, not the set.
+func VerifyUTXOProof(proof *UTXOProof, root string) (TXOutput, bool, error) {
+	if proof == nil || len(proof.Siblings) > 256 {
+		return TXOutput{}, false, ErrBadUTXOProof
+	}
+	keyHash := utxoKeyHash(proof.Key)
+	depth := len(proof.Siblings)
+	var h [32]byte
+	switch {
+	case proof.Output != nil && proof.OtherKey == nil:
+		h = utxoLeafHash(keyHash, *proof.Output)
+	case proof.Output == nil && proof.OtherKey != nil && proof.OtherOutput != nil:
+		other := utxoKeyHash(*proof.OtherKey)
+		if other == keyHash {
+			return TXOutput{}, false, ErrBadUTXOProof
+		}
+		for i := 0; i < depth; i++ {
+			if bit(other, i) != bit(keyHash, i) {
+				return TXOutput{}, false, ErrBadUTXOProof
+			}
+		}
+		h = utxoLeafHash(other, *proof.OtherOutput)
+	case proof.Output == nil && proof.OtherKey == nil:
+		// the path ends in an empty subtree, whose hash is zero
+	default:
+		return TXOutput{}, false, ErrBadUTXOProof
+	}
+	for i := depth - 1; i >= 0; i-- {
+		raw, err := hex.DecodeString(proof.Siblings[i])
+		if err != nil || len(raw) != 32 {
+			return TXOutput{}, false, ErrBadUTXOProof
+		}
+		var s [32]byte
+		copy(s[:], raw)
+		if bit(keyHash, i) == 0 {
+			h = nodeHash(h, s)
+		} else {
+			h = nodeHash(s, h)
+		}
+	}
+	if hex.EncodeToString(h[:]) != root {
+		return TXOutput{}, false, ErrBadUTXOProof
+	}
+	if proof.Output == nil {
+		return TXOutput{}, false, nil
+	}
+	return *proof.Output, true, nil
+}
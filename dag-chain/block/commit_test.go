@@ -0,0 +1,78 @@
+package block_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+func TestUTXOCommitment(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c, err := block.Commit(block.NewUTXOTrie())
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := block.UTXOSet{}
+	key := func(i int) block.UTXOKey { return block.UTXOKey{TxID: fmt.Sprintf("tx%d", i), OutIndex: i % 3} }
+	var snaps []*block.CommittedStore
+	var roots []string
+	for step := 0; step < 600; step++ {
+		k := key(rng.Intn(200))
+		if rng.Intn(3) == 0 {
+			if err := c.Delete(k); err != nil {
+				t.Fatal(err)
+			}
+			delete(model, k)
+		} else {
+			out := block.TXOutput{Value: uint64(rng.Intn(100)), Recipient: "r"}
+			if err := c.Put(k, out); err != nil {
+				t.Fatal(err)
+			}
+			model[k] = out
+		}
+		if step%50 == 0 {
+			snap, err := c.Fork()
+			if err != nil {
+				t.Fatal(err)
+			}
+			snaps, roots = append(snaps, snap), append(roots, c.Root())
+		}
+	}
+
+	// the root depends only on the contents, not on the history
+	rebuilt, err := block.Commit(model.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Root() != c.Root() {
+		t.Fatalf("incremental root %s, rebuilt %s", c.Root(), rebuilt.Root())
+	}
+	for i, s := range snaps {
+		if s.Root() != roots[i] {
+			t.Fatalf("snapshot %d root changed after later writes", i)
+		}
+	}
+
+	root := c.Root()
+	for i := 0; i < 250; i++ {
+		k := key(i)
+		proof := c.Prove(k)
+		out, ok, err := block.VerifyUTXOProof(proof, root)
+		want, has := model[k]
+		if err != nil || ok != has || out != want {
+			t.Fatalf("%v: proof gives %v %v %v, want %v %v", k, out, ok, err, want, has)
+		}
+		if has {
+			proof.Output.Value++
+		} else {
+			proof.Output = &block.TXOutput{Recipient: "r"}
+			proof.OtherKey, proof.OtherOutput = nil, nil
+		}
+		if _, _, err := block.VerifyUTXOProof(proof, root); !errors.Is(err, block.ErrBadUTXOProof) {
+			t.Fatalf("%v: tampered proof accepted", k)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package block
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrMalformed is returned when decoding bytes that are not a canonical
+// encoding.
+var ErrMalformed = errors.New("malformed encoding")
+
+// decoder reads what encoder writes: lengths are fixed-width, where
+// Decoder's are uvarints.
+type decoder struct {
+	Decoder
+}
+
+// count reads a length that must fit in the remaining input, each item
+// taking at least min bytes, so a forged length cannot force a huge
+// allocation.
+func (d *decoder) count(min int) int {
+	n := d.Uint64()
+	if d.err == nil && n > uint64(len(d.buf)/min) {
+		d.Fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	return append([]byte(nil), d.next(d.count(1))...)
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.count(1)))
+}
+
+func (d *decoder) tx() TX {
+	var tx TX
+	if n := d.count(32); n > 0 {
+		tx.Inputs = make([]TXInput, n)
+		for i := range tx.Inputs {
+			in := &tx.Inputs[i]
+			in.PrevTxID = d.string()
+			in.OutputIndex = int(d.Uint64())
+			in.PubKey = d.bytes()
+			in.Signature = d.bytes()
+		}
+	}
+	if n := d.count(16); n > 0 {
+		tx.Outputs = make([]TXOutput, n)
+		for i := range tx.Outputs {
+			tx.Outputs[i].Value = d.Uint64()
+			tx.Outputs[i].Recipient = d.string()
+		}
+	}
+	tx.Extra = d.bytes()
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func (d *decoder) header() Header {
+	var h Header
+	if n := d.count(8); n > 0 {
+		h.Parents = make([]string, n)
+		for i := range h.Parents {
+			h.Parents[i] = d.string()
+		}
+	}
+	h.Timestamp = time.Unix(0, int64(d.Uint64()))
+	h.TxRoot = d.string()
+	h.UTXORoot = d.string()
+	h.Difficulty = d.Uint64()
+	h.Nonce = d.Uint64()
+	h.Producer = d.bytes()
+	return h
+}
+
+// DecodeHeader parses the output of Header.Encode.
+func DecodeHeader(b []byte) (*Header, error) {
+	d := decoder{Decoder{buf: b}}
+	h := d.header()
+	if err := d.Done(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// DecodeTX parses the output of TX.EncodeWitness and sets the ID.
+func DecodeTX(b []byte) (TX, error) {
+	d := decoder{Decoder{buf: b}}
+	tx := d.tx()
+	if err := d.Done(); err != nil {
+		return TX{}, err
+	}
+	return tx, nil
+}
+
+// DecodeBlock parses the output of Block.Encode and sets the block's and
+// transactions' IDs from their content. It does not check TxRoot.
+func DecodeBlock(b []byte) (*Block, error) {
+	d := decoder{Decoder{buf: b}}
+	blk := &Block{Header: d.header(), Signature: d.bytes()}
+	if n := d.count(24); n > 0 {
+		blk.TXs = make([]TX, n)
+		for i := range blk.TXs {
+			blk.TXs[i] = d.tx()
+		}
+	}
+	if err := d.Done(); err != nil {
+		return nil, err
+	}
+	blk.ID = blk.Hash()
+	return blk, nil
+}
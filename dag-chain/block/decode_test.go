@@ -0,0 +1,75 @@
+package block_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+func TestDecodeBlock_RoundTrip(t *testing.T) {
+	key := block.KeyFromSeed(make([]byte, 32))
+	spend := block.NewTX([]block.TXInput{{PrevTxID: "prev", OutputIndex: 2}},
+		[]block.TXOutput{{Value: 5, Recipient: key.Address()}}, nil)
+	spend.Sign(0, key)
+	mint := block.NewTX(nil, []block.TXOutput{{Value: 50, Recipient: "m"}}, []byte("extra"))
+	blk := block.NewBlock([]string{"a", "b"}, []block.TX{mint, spend}, time.Unix(1700000000, 123))
+	blk.UTXORoot = "root"
+	blk.Difficulty, blk.Nonce = 7, 9
+	blk.Producer = key.Public
+	blk.ID = blk.Hash()
+	blk.Sign(key)
+
+	got, err := block.DecodeBlock(blk.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != blk.ID || !bytes.Equal(got.Encode(), blk.Encode()) || got.TXs[1].ID != spend.ID {
+		t.Fatalf("round trip changed the block: %+v", got)
+	}
+	if err := errors.Join(got.CheckTxRoot(), got.CheckSignature()); err != nil {
+		t.Error(err)
+	}
+	h, err := block.DecodeHeader(blk.Header.Encode())
+	if err != nil || h.Hash() != blk.ID {
+		t.Fatalf("header round trip: %v", err)
+	}
+
+	tx, err := block.DecodeTX(spend.EncodeWitness())
+	if err != nil || tx.ID != spend.ID || !bytes.Equal(tx.Inputs[0].Signature, spend.Inputs[0].Signature) {
+		t.Fatalf("tx round trip: %v", err)
+	}
+
+	enc := blk.Encode()
+	for _, bad := range [][]byte{nil, enc[:len(enc)-1], append(enc, 0)} {
+		if _, err := block.DecodeBlock(bad); !errors.Is(err, block.ErrMalformed) {
+			t.Errorf("%d bytes: got %v, want ErrMalformed", len(bad), err)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	b := block.AppendString(nil, "id")
+	b = block.AppendBytes(b, []byte{1, 2})
+	d := block.NewDecoder(b)
+	if s, p := d.String(), d.Bytes(); s != "id" || !bytes.Equal(p, []byte{1, 2}) {
+		t.Fatalf("got %q %v", s, p)
+	}
+	if err := d.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a length longer than the input, then reads past the failure
+	d = block.NewDecoder([]byte{200, 1, 'x'})
+	if s, n := d.String(), d.Uvarint(); s != "" || n != 0 {
+		t.Fatalf("got %q %d after a bad length", s, n)
+	}
+	if err := d.Done(); !errors.Is(err, block.ErrMalformed) {
+		t.Fatalf("got %v, want ErrMalformed", err)
+	}
+	if err := block.NewDecoder([]byte{0, 0}).Done(); !errors.Is(err, block.ErrMalformed) {
+		t.Fatalf("left over input: got %v, want ErrMalformed", err)
+	}
+}
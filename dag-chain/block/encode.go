@@ -0,0 +1,143 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// encoder writes the canonical binary form used for content addressing.
+// Integers are fixed-width big-endian and every variable-length field is
+// length-prefixed, so two different values never share an encoding.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint64(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uint64(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+// tx encodes tx; witness selects whether input public keys and signatures
+// are included.
+func (e *encoder) tx(tx *TX, witness bool) {
+	e.uint64(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		e.string(in.PrevTxID)
+		e.uint64(uint64(in.OutputIndex))
+		if witness {
+			e.bytes(in.PubKey)
+			e.bytes(in.Signature)
+		}
+	}
+	e.uint64(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		e.uint64(out.Value)
+		e.string(out.Recipient)
+	}
+	e.bytes(tx.Extra)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Encode returns the canonical serialization of tx. Neither the ID nor the
+// input witnesses are part of it, so signing does not change a TX's ID.
+func (tx *TX) Encode() []byte {
+	var e encoder
+	e.tx(tx, false)
+	return e.buf.Bytes()
+}
+
+// EncodeWitness returns tx's encoding with witnesses, as it appears in
+// Block.Encode. DecodeTX parses it.
+func (tx *TX) EncodeWitness() []byte {
+	var e encoder
+	e.tx(tx, true)
+	return e.buf.Bytes()
+}
+
+// Size is the length in bytes of tx's encoding with witnesses, the size it
+// takes up in a block.
+func (tx *TX) Size() int {
+	return len(tx.EncodeWitness())
+}
+
+// Hash returns the hex SHA-256 of tx's canonical encoding.
+func (tx *TX) Hash() string {
+	return hashHex(tx.Encode())
+}
+
+// Encode returns the canonical serialization of h.
+func (h *Header) Encode() []byte {
+	var e encoder
+	e.header(h)
+	return e.buf.Bytes()
+}
+
+// Hash returns the hex SHA-256 of h's canonical encoding, the block ID.
+func (h *Header) Hash() string {
+	return hashHex(h.Encode())
+}
+
+func (e *encoder) header(h *Header) {
+	e.uint64(uint64(len(h.Parents)))
+	for _, p := range h.Parents {
+		e.string(p)
+	}
+	e.uint64(uint64(h.Timestamp.UnixNano()))
+	e.string(h.TxRoot)
+	e.string(h.UTXORoot)
+	e.uint64(h.Difficulty)
+	e.uint64(h.Nonce)
+	e.bytes(h.Producer)
+}
+
+// Encode returns the canonical serialization of b: the header, the
+// producer's signature, then the full content of every transaction,
+// witnesses included, in order.
+func (b *Block) Encode() []byte {
+	var e encoder
+	e.header(&b.Header)
+	e.bytes(b.Signature)
+	e.uint64(uint64(len(b.TXs)))
+	for i := range b.TXs {
+		e.tx(&b.TXs[i], true)
+	}
+	return e.buf.Bytes()
+}
+
+// Hash returns the hash of b's header. The transactions count through
+// TxRoot, which CheckTxRoot verifies.
+func (b *Block) Hash() string {
+	return b.Header.Hash()
+}
+
+// NewTX builds a transaction and sets its content-addressed ID.
+func NewTX(inputs []TXInput, outputs []TXOutput, extra []byte) TX {
+	tx := TX{Inputs: inputs, Outputs: outputs, Extra: extra}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewBlock builds a block and sets its TxRoot and content-addressed ID.
+func NewBlock(parents []string, txs []TX, ts time.Time) *Block {
+	b := &Block{Header: Header{Parents: parents, Timestamp: ts, TxRoot: MerkleRoot(txs)}, TXs: txs}
+	b.ID = b.Hash()
+	return b
+}
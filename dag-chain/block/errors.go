@@ -0,0 +1,101 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validation failures reported by ApplyTx, ApplyBlock, CheckTxRoot and
+// CheckSignature. Every error they return wraps exactly one of these, so
+// callers can classify it with errors.Is.
+var (
+	ErrIDMismatch         = errors.New("id does not match content hash")
+	ErrMissingInput       = errors.New("input not found or already spent")
+	ErrDuplicateInput     = errors.New("input listed twice in one transaction")
+	ErrDoubleSpend        = errors.New("output spent by two transactions in one block")
+	ErrDuplicateOutput    = errors.New("duplicate output key")
+	ErrMissingSignature   = errors.New("missing signature or public key")
+	ErrWrongKey           = errors.New("public key does not own the output")
+	ErrBadSignature       = errors.New("invalid signature")
+	ErrValueOverflow      = errors.New("value overflows uint64")
+	ErrInsufficientInputs = errors.New("outputs exceed inputs")
+	ErrUnexpectedMint     = errors.New("transaction without inputs outside coinbase position")
+	ErrCoinbaseTooLarge   = errors.New("coinbase exceeds subsidy plus fees")
+	ErrTxRootMismatch     = errors.New("transactions do not match the header's tx root")
+)
+
+// ErrTxNotInBlock is returned by ProveTx for a transaction the block does
+// not contain.
+var ErrTxNotInBlock = errors.New("transaction not in block")
+
+// validationErrors lists every sentinel above, for IsInvalid.
+var validationErrors = []error{
+	ErrIDMismatch, ErrMissingInput, ErrDuplicateInput, ErrDoubleSpend,
+	ErrDuplicateOutput, ErrMissingSignature, ErrWrongKey, ErrBadSignature,
+	ErrValueOverflow, ErrInsufficientInputs, ErrUnexpectedMint, ErrCoinbaseTooLarge,
+	ErrTxRootMismatch,
+}
+
+// IsInvalid reports whether err is a validation failure, as opposed to an
+// error from the underlying UTXOStore.
+func IsInvalid(err error) bool {
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureError reports which input of which transaction failed
+// ownership checks.
+type SignatureError struct {
+	TxID  string
+	Input int
+	Err   error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("tx %s input %d: %v", e.TxID, e.Input, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+// ConflictError reports an outpoint spent twice. For ErrDuplicateInput
+// both IDs name the same transaction; for ErrDoubleSpend FirstTx comes
+// before SecondTx in the block.
+type ConflictError struct {
+	Outpoint UTXOKey
+	FirstTx  string
+	SecondTx string
+	Err      error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s:%d spent by %s and %s",
+		e.Err, e.Outpoint.TxID, e.Outpoint.OutIndex, e.FirstTx, e.SecondTx)
+}
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// CheckDoubleSpends reports the first outpoint that txs spend more than
+// once, either within one transaction or across two, without consulting
+// any UTXO set.
+func CheckDoubleSpends(txs []TX) error {
+	spentBy := make(map[UTXOKey]string)
+	for _, tx := range txs {
+		seen := make(map[UTXOKey]struct{}, len(tx.Inputs))
+		for _, in := range tx.Inputs {
+			key := UTXOKey{TxID: in.PrevTxID, OutIndex: in.OutputIndex}
+			if _, dup := seen[key]; dup {
+				return &ConflictError{Outpoint: key, FirstTx: tx.ID, SecondTx: tx.ID, Err: ErrDuplicateInput}
+			}
+			seen[key] = struct{}{}
+			if first, dup := spentBy[key]; dup {
+				return &ConflictError{Outpoint: key, FirstTx: first, SecondTx: tx.ID, Err: ErrDoubleSpend}
+			}
+			spentBy[key] = tx.ID
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed under different prefixes, so an inner
+// node can never pass for a transaction. An odd node out is carried up a
+// level unchanged rather than paired with itself, which leaves the same
+// path valid for more than one tree size; the root therefore also commits
+// to the number of transactions.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+	rootPrefix = 0x02
+)
+
+func leafHash(tx *TX) [32]byte {
+	var e encoder
+	e.buf.WriteByte(leafPrefix)
+	e.tx(tx, true)
+	return sha256.Sum256(e.buf.Bytes())
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	buf := make([]byte, 0, 65)
+	buf = append(buf, nodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// rootHash binds the top of a tree to the number of leaves under it.
+func rootHash(top [32]byte, count int) [32]byte {
+	buf := make([]byte, 0, 41)
+	buf = append(buf, rootPrefix)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(count))
+	buf = append(buf, top[:]...)
+	return sha256.Sum256(buf)
+}
+
+// merkleLevels returns every level of the tree over txs, leaves first.
+func merkleLevels(txs []TX) [][][32]byte {
+	level := make([][32]byte, len(txs))
+	for i := range txs {
+		level[i] = leafHash(&txs[i])
+	}
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot returns the hex root of the Merkle tree whose leaves are the
+// transactions' full encodings, witnesses included, hashed together with
+// their count. With no transactions it is the hash of nothing.
+func MerkleRoot(txs []TX) string {
+	if len(txs) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+	levels := merkleLevels(txs)
+	root := rootHash(levels[len(levels)-1][0], len(txs))
+	return hex.EncodeToString(root[:])
+}
+
+// CheckTxRoot verifies that the header's TxRoot commits to b.TXs.
+func (b *Block) CheckTxRoot() error {
+	if got := MerkleRoot(b.TXs); got != b.TxRoot {
+		return fmt.Errorf("block %s: %w: header has %s, transactions give %s", b.ID, ErrTxRootMismatch, b.TxRoot, got)
+	}
+	return nil
+}
+
+// MerkleProof shows that the transaction at Index, out of Count in a
+// block, hashes up to the block's TxRoot through Siblings, lowest first.
+type MerkleProof struct {
+	Index    int
+	Count    int
+	Siblings []string // hex hashes
+}
+
+// ProveTx returns an inclusion proof for one of b's transactions.
+func (b *Block) ProveTx(txID string) (*MerkleProof, error) {
+	idx := -1
+	for i := range b.TXs {
+		if b.TXs[i].ID == txID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("block %s: %w: %s", b.ID, ErrTxNotInBlock, txID)
+	}
+	proof := &MerkleProof{Index: idx, Count: len(b.TXs)}
+	levels := merkleLevels(b.TXs)
+	for _, level := range levels[:len(levels)-1] {
+		if sib := idx ^ 1; sib < len(level) {
+			proof.Siblings = append(proof.Siblings, hex.EncodeToString(level[sib][:]))
+		}
+		idx /= 2
+	}
+	return proof, nil
+}
+
+// VerifyTxProof reports whether proof shows tx is in a block whose header
+// carries root as its TxRoot. It needs only the header, not the block.
+func VerifyTxProof(tx *TX, proof *MerkleProof, root string) bool {
+	if proof == nil || proof.Index < 0 || proof.Index >= proof.Count {
+		return false
+	}
+	h := leafHash(tx)
+	idx, n, used := proof.Index, proof.Count, 0
+	for n > 1 {
+		if sib := idx ^ 1; sib < n {
+			if used == len(proof.Siblings) {
+				return false
+			}
+			raw, err := hex.DecodeString(proof.Siblings[used])
+			if err != nil || len(raw) != 32 {
+				return false
+			}
+			used++
+			var s [32]byte
+			copy(s[:], raw)
+			if idx%2 == 0 {
+				h = nodeHash(h, s)
+			} else {
+				h = nodeHash(s, h)
+			}
+		}
+		idx /= 2
+		n = (n + 1) / 2
+	}
+	h = rootHash(h, proof.Count)
+	return used == len(proof.Siblings) && hex.EncodeToString(h[:]) == root
+}
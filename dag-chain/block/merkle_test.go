@@ -0,0 +1,78 @@
+package block_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+func mints(n int) []block.TX {
+	txs := make([]block.TX, n)
+	for i := range txs {
+		txs[i] = block.NewTX(nil, []block.TXOutput{{Value: uint64(i), Recipient: "r"}}, []byte(fmt.Sprint(i)))
+	}
+	return txs
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		b := block.NewBlock([]string{"p"}, mints(n), time.Unix(1700000000, 0))
+		for i := range b.TXs {
+			proof, err := b.ProveTx(b.TXs[i].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !block.VerifyTxProof(&b.TXs[i], proof, b.TxRoot) {
+				t.Fatalf("n=%d: proof for tx %d does not verify", n, i)
+			}
+			other := b.TXs[(i+1)%n]
+			if n > 1 && block.VerifyTxProof(&other, proof, b.TxRoot) {
+				t.Fatalf("n=%d: proof for tx %d verifies another tx", n, i)
+			}
+			if len(proof.Siblings) > 0 {
+				bad := *proof
+				bad.Siblings = append([]string(nil), proof.Siblings...)
+				bad.Siblings[0] = b.TxRoot
+				if block.VerifyTxProof(&b.TXs[i], &bad, b.TxRoot) {
+					t.Fatalf("n=%d: tampered proof verifies", n)
+				}
+			}
+		}
+	}
+
+	b := block.NewBlock([]string{"p"}, mints(3), time.Unix(1700000000, 0))
+	// the last of three is carried up past the first level, so its path
+	// is also that of the second of two; the count tells them apart
+	proof, err := b.ProveTx(b.TXs[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range [][2]int{{1, 2}, {2, 4}, {0, 3}} {
+		bad := *proof
+		bad.Index, bad.Count = pos[0], pos[1]
+		if block.VerifyTxProof(&b.TXs[2], &bad, b.TxRoot) {
+			t.Errorf("proof verifies as index %d of %d", pos[0], pos[1])
+		}
+	}
+	if _, err := b.ProveTx("absent"); !errors.Is(err, block.ErrTxNotInBlock) {
+		t.Errorf("expected ErrTxNotInBlock, got %v", err)
+	}
+}
+
+func TestCheckTxRoot(t *testing.T) {
+	b := block.NewBlock([]string{"p"}, mints(2), time.Unix(1700000000, 0))
+	if err := b.CheckTxRoot(); err != nil {
+		t.Fatal(err)
+	}
+	// swapping the body keeps the header, and so the ID, valid
+	b.TXs = mints(3)
+	if b.ID != b.Hash() {
+		t.Fatal("header hash changed with the body")
+	}
+	if err := b.CheckTxRoot(); !errors.Is(err, block.ErrTxRootMismatch) || !block.IsInvalid(err) {
+		t.Errorf("expected ErrTxRootMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package block
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+// maxHash is 2^256, one past the largest SHA-256 value.
+var maxHash = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// Target returns the largest block hash that meets difficulty:
+// 2^256 / difficulty - 1. Difficulty 0 has no target.
+func Target(difficulty uint64) *big.Int {
+	if difficulty == 0 {
+		return nil
+	}
+	t := new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+	return t.Sub(t, big.NewInt(1))
+}
+
+// MeetsTarget reports whether b's ID is a hash at or below the target for
+// b.Difficulty. Expected work to find one is Difficulty hashes.
+func (b *Block) MeetsTarget() bool {
+	return meetsTarget(b.ID, Target(b.Difficulty))
+}
+
+func meetsTarget(id string, target *big.Int) bool {
+	if target == nil {
+		return false
+	}
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != 32 {
+		return false
+	}
+	return new(big.Int).SetBytes(raw).Cmp(target) <= 0
+}
+
+// Mine tries up to maxTries nonces, starting from the current one, for an
+// ID that meets b.Difficulty. It sets Nonce and ID and reports success.
+func (b *Block) Mine(maxTries uint64) bool {
+	target := Target(b.Difficulty)
+	if target == nil {
+		return false
+	}
+	for i := uint64(0); i < maxTries; i++ {
+		b.ID = b.Hash()
+		if meetsTarget(b.ID, target) {
+			return true
+		}
+		b.Nonce++
+	}
+	b.ID = b.Hash()
+	return false
+}
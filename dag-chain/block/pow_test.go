@@ -0,0 +1,32 @@
+package block_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+func TestMine(t *testing.T) {
+	b := block.NewBlock([]string{"parent"}, nil, time.Unix(1700000000, 0))
+	if b.MeetsTarget() {
+		t.Fatal("difficulty 0 has no target")
+	}
+	b.Difficulty = 1
+	if !b.Mine(1) {
+		t.Fatal("difficulty 1 accepts any hash")
+	}
+
+	b.Difficulty = 1 << 12
+	if !b.Mine(1 << 20) {
+		t.Fatal("no nonce found")
+	}
+	if b.ID != b.Hash() || !b.MeetsTarget() {
+		t.Fatal("mined block does not verify")
+	}
+	// the hash also commits to the difficulty
+	b.Difficulty <<= 20
+	if b.ID = b.Hash(); b.MeetsTarget() {
+		t.Error("raising the difficulty kept the old proof valid")
+	}
+}
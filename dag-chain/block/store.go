@@ -0,0 +1,48 @@
+package block
+
+// UTXOStore is the storage behind a UTXO view. UTXOSet is the in-memory
+// implementation; package store provides one backed by a file.
+type UTXOStore interface {
+	// Get returns the output under key and whether it exists.
+	Get(key UTXOKey) (TXOutput, bool, error)
+	// Put adds or replaces the output under key.
+	Put(key UTXOKey, out TXOutput) error
+	// Delete removes key; deleting a missing key is not an error.
+	Delete(key UTXOKey) error
+	// Iterate calls fn for every entry, in no particular order, until fn
+	// returns false. fn must not modify the store.
+	Iterate(fn func(UTXOKey, TXOutput) bool) error
+	// Snapshot returns an independent store with the same contents;
+	// later writes to either are not visible in the other.
+	Snapshot() (UTXOStore, error)
+}
+
+var _ UTXOStore = UTXOSet(nil)
+
+func (u UTXOSet) Get(key UTXOKey) (TXOutput, bool, error) {
+	out, ok := u[key]
+	return out, ok, nil
+}
+
+func (u UTXOSet) Put(key UTXOKey, out TXOutput) error {
+	u[key] = out
+	return nil
+}
+
+func (u UTXOSet) Delete(key UTXOKey) error {
+	delete(u, key)
+	return nil
+}
+
+func (u UTXOSet) Iterate(fn func(UTXOKey, TXOutput) bool) error {
+	for k, v := range u {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (u UTXOSet) Snapshot() (UTXOStore, error) {
+	return u.Clone(), nil
+}
@@ -0,0 +1,78 @@
+package block
+
+import (
+	"hash/fnv"
+
+	"github.com/Abdullah-zahoor/dagchain/internal/hamt"
+)
+
+// UTXOTrie is a persistent UTXOStore: a hash array mapped trie whose
+// Snapshot is O(1) and shares every node with the original. A write copies
+// only the path to the changed leaf, so a DAG node built from its parent's
+// snapshot costs memory proportional to its own transactions.
+//
+// Nodes are copy-on-write: a trie mutates in place only the nodes it
+// allocated since its last Snapshot. Reads and Snapshot may run
+// concurrently with each other, as on a DAG node's state, but not with a
+// write.
+type UTXOTrie struct {
+	m *hamt.Map[UTXOKey, TXOutput]
+}
+
+var _ UTXOStore = (*UTXOTrie)(nil)
+
+// NewUTXOTrie returns an empty trie.
+func NewUTXOTrie() *UTXOTrie {
+	return &UTXOTrie{m: hamt.New[UTXOKey, TXOutput](hashKey)}
+}
+
+// Len returns the number of entries.
+func (t *UTXOTrie) Len() int {
+	return t.m.Len()
+}
+
+func hashKey(k UTXOKey) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(k.TxID))
+	var idx [8]byte
+	for i := range idx {
+		idx[i] = byte(uint64(k.OutIndex) >> (8 * i))
+	}
+	h.Write(idx[:])
+	return h.Sum64()
+}
+
+func (t *UTXOTrie) Get(key UTXOKey) (TXOutput, bool, error) {
+	out, ok := t.m.Get(key)
+	return out, ok, nil
+}
+
+func (t *UTXOTrie) Put(key UTXOKey, out TXOutput) error {
+	t.m.Put(key, out)
+	return nil
+}
+
+func (t *UTXOTrie) Delete(key UTXOKey) error {
+	t.m.Delete(key)
+	return nil
+}
+
+// Iterate visits entries in hash order, which is the same for equal sets.
+func (t *UTXOTrie) Iterate(fn func(UTXOKey, TXOutput) bool) error {
+	t.m.Each(fn)
+	return nil
+}
+
+// Snapshot is O(1): both tries keep the current nodes and copy them on
+// their next write.
+func (t *UTXOTrie) Snapshot() (UTXOStore, error) {
+	return &UTXOTrie{m: t.m.Snapshot()}, nil
+}
+
+// Intersect drops every entry of t whose key other does not hold. Subtrees
+// the two tries share are skipped, so the cost is proportional to how far
+// they have diverged rather than to their size. Afterwards t may share
+// nodes with other, so both copy them on their next write.
+func (t *UTXOTrie) Intersect(other *UTXOTrie) {
+	t.m.Intersect(other.m)
+}
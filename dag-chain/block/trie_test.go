@@ -0,0 +1,135 @@
+package block_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// contents copies any store into a map for comparison.
+func contents(t testing.TB, s block.UTXOStore) block.UTXOSet {
+	t.Helper()
+	m := make(block.UTXOSet)
+	if err := s.Iterate(func(k block.UTXOKey, v block.TXOutput) bool {
+		m[k] = v
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUTXOTrie_MatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	trie := block.NewUTXOTrie()
+	model := make(block.UTXOSet)
+	var snaps []*block.UTXOTrie
+	var snapModels []block.UTXOSet
+
+	for i := 0; i < 20000; i++ {
+		key := block.UTXOKey{TxID: fmt.Sprintf("tx%d", rng.Intn(3000)), OutIndex: rng.Intn(3)}
+		switch rng.Intn(10) {
+		case 0, 1, 2:
+			trie.Delete(key)
+			delete(model, key)
+		case 3:
+			if i%500 == 0 {
+				s, _ := trie.Snapshot()
+				snaps = append(snaps, s.(*block.UTXOTrie))
+				snapModels = append(snapModels, model.Clone())
+			}
+		default:
+			out := block.TXOutput{Value: uint64(i)}
+			trie.Put(key, out)
+			model[key] = out
+		}
+	}
+
+	if trie.Len() != len(model) {
+		t.Fatalf("Len = %d, want %d", trie.Len(), len(model))
+	}
+	if got := contents(t, trie); !reflect.DeepEqual(got, model) {
+		t.Fatal("trie contents differ from model")
+	}
+	for i, s := range snaps {
+		if got := contents(t, s); !reflect.DeepEqual(got, snapModels[i]) {
+			t.Fatalf("snapshot %d changed after later writes", i)
+		}
+	}
+}
+
+func TestUTXOTrie_Intersect(t *testing.T) {
+	base := block.NewUTXOTrie()
+	for i := 0; i < 5000; i++ {
+		base.Put(block.UTXOKey{TxID: fmt.Sprintf("g%d", i)}, block.TXOutput{Value: uint64(i)})
+	}
+	s1, _ := base.Snapshot()
+	s2, _ := base.Snapshot()
+	a, b := s1.(*block.UTXOTrie), s2.(*block.UTXOTrie)
+
+	// a spends g0..g99 and mints a0; b spends g50..g149 and mints b0
+	for i := 0; i < 100; i++ {
+		a.Delete(block.UTXOKey{TxID: fmt.Sprintf("g%d", i)})
+		b.Delete(block.UTXOKey{TxID: fmt.Sprintf("g%d", i+50)})
+	}
+	a.Put(block.UTXOKey{TxID: "a0"}, block.TXOutput{Value: 1})
+	b.Put(block.UTXOKey{TxID: "b0"}, block.TXOutput{Value: 2})
+
+	want := contents(t, a)
+	for k := range want {
+		if _, ok, _ := b.Get(k); !ok {
+			delete(want, k)
+		}
+	}
+	a.Intersect(b)
+	if got := contents(t, a); !reflect.DeepEqual(got, want) || a.Len() != len(want) {
+		t.Fatalf("intersection has %d entries (Len %d), want %d", len(got), a.Len(), len(want))
+	}
+	if _, ok, _ := base.Get(block.UTXOKey{TxID: "g0"}); !ok {
+		t.Error("intersection modified the shared base")
+	}
+}
+
+// BenchmarkIntersect intersects two states that share size outputs and
+// have each spent and minted a few: a map is cloned and scanned, while a
+// trie skips the subtrees the two still share.
+func BenchmarkIntersect(b *testing.B) {
+	for _, size := range []int{1_000, 10_000, 100_000} {
+		base := block.NewUTXOTrie()
+		for i := 0; i < size; i++ {
+			base.Put(block.UTXOKey{TxID: fmt.Sprintf("g%d", i)}, block.TXOutput{Value: 1})
+		}
+		s1, _ := base.Snapshot()
+		s2, _ := base.Snapshot()
+		x, y := s1.(*block.UTXOTrie), s2.(*block.UTXOTrie)
+		for i := 0; i < 10; i++ {
+			x.Delete(block.UTXOKey{TxID: fmt.Sprintf("g%d", i)})
+			y.Delete(block.UTXOKey{TxID: fmt.Sprintf("g%d", i+5)})
+			x.Put(block.UTXOKey{TxID: fmt.Sprintf("x%d", i)}, block.TXOutput{Value: 1})
+			y.Put(block.UTXOKey{TxID: fmt.Sprintf("y%d", i)}, block.TXOutput{Value: 1})
+		}
+		mx, my := contents(b, x), contents(b, y)
+
+		b.Run(fmt.Sprintf("clone-intersect/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c := mx.Clone()
+				for k := range c {
+					if _, ok := my[k]; !ok {
+						delete(c, k)
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("trie/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s, _ := x.Snapshot()
+				s.(*block.UTXOTrie).Intersect(y)
+			}
+		})
+	}
+}
@@ -2,29 +2,49 @@ package block
 
 import "time"
 
-// TXInput references a previous TX output.
+// TXInput references a previous TX output and carries the witness that
+// proves ownership of it.
 type TXInput struct {
 	PrevTxID    string
 	OutputIndex int
+	PubKey      []byte // Ed25519 key whose Address matches the output
+	Signature   []byte // signature over the spending TX's SigHash
 }
 
 // TXOutput represents a new unspent output.
 type TXOutput struct {
 	Value     uint64
-	Recipient string
+	Recipient string // Address of the owning key
 }
 
 // TX is a UTXO‐style transaction.
 type TX struct {
-	ID      string
+	ID      string // hash of the canonical encoding, see NewTX
 	Inputs  []TXInput
 	Outputs []TXOutput
+	Extra   []byte // arbitrary data; keeps otherwise identical mints distinct
 }
 
-// Block can reference multiple parents.
-type Block struct {
-	ID        string
+// Header is the part of a block that its ID commits to. It commits to the
+// transactions through TxRoot, so headers alone can be relayed, checked
+// for work and used to verify inclusion proofs.
+type Header struct {
 	Parents   []string  // parent block IDs
-	TXs       []TX      // included transactions
 	Timestamp time.Time // creation time
+	TxRoot    string    // Merkle root of the transactions, see MerkleRoot
+	UTXORoot  string    // commitment to the UTXO set; empty when not committed
+
+	Difficulty uint64 // proof-of-work difficulty the ID meets; 0 without PoW
+	Nonce      uint64 // varied by Mine to meet Difficulty
+
+	Producer []byte // Ed25519 key of the validator that built the block; empty if anonymous
+}
+
+// Block is a header and its body, the transactions. It can reference
+// multiple parents.
+type Block struct {
+	ID string // hash of the header, see NewBlock
+	Header
+	Signature []byte // by Producer over the ID, see Sign; not part of the ID
+	TXs       []TX   // included transactions
 }
@@ -14,6 +14,27 @@ type UTXOKey struct {
 // UTXOSet maps each UTXOKey to its corresponding output.
 type UTXOSet map[UTXOKey]TXOutput
 
+// SpentOutput is an output consumed by a transaction, kept for undo.
+type SpentOutput struct {
+	Key    UTXOKey
+	Output TXOutput
+}
+
+// TxUndo records everything a transaction changed in a UTXOStore so that
+// RevertTx can walk it back.
+type TxUndo struct {
+	TxID    string
+	Created []TXOutput    // outputs added under TxID, by index
+	Spent   []SpentOutput // inputs removed, in input order
+	Fee     uint64
+}
+
+// BlockUndo records a block's transactions in the order they were applied.
+type BlockUndo struct {
+	Txs  []TxUndo
+	Fees uint64
+}
+
 func (u UTXOSet) Clone() UTXOSet {
 	dup := make(UTXOSet, len(u))
 	for k, v := range u {
@@ -22,23 +43,228 @@ func (u UTXOSet) Clone() UTXOSet {
 	return dup
 }
 
-func (u UTXOSet) ApplyTx(tx TX) error {
-	// Check & remove inputs
-	for _, in := range tx.Inputs {
-		key := UTXOKey{TxID: in.PrevTxID, OutIndex: in.OutputIndex}
-		if _, exists := u[key]; !exists {
-			return fmt.Errorf("input not found or already spent: %v", key)
+// ApplyTx is the package-level ApplyTx on u.
+func (u UTXOSet) ApplyTx(tx TX) (TxUndo, error) { return ApplyTx(u, tx) }
+
+// RevertTx is the package-level RevertTx on u.
+func (u UTXOSet) RevertTx(undo TxUndo) error { return RevertTx(u, undo) }
+
+// ApplyBlock is the package-level ApplyBlock on u.
+func (u UTXOSet) ApplyBlock(txs []TX, subsidy uint64) (*BlockUndo, error) {
+	return ApplyBlock(u, txs, subsidy)
+}
+
+// UndoBlock is the package-level UndoBlock on u.
+func (u UTXOSet) UndoBlock(undo *BlockUndo) error { return UndoBlock(u, undo) }
+
+// ApplyTx spends tx's inputs from s and adds its outputs. tx must spend at
+// least one input and its inputs must cover its outputs; mints are only
+// valid as a block's coinbase, see ApplyBlock. ApplyTx is all-or-nothing:
+// on error s is left unchanged.
+func ApplyTx(s UTXOStore, tx TX) (TxUndo, error) {
+	undo := TxUndo{TxID: tx.ID}
+	if err := applyTx(s, tx, &undo); err != nil {
+		return TxUndo{}, errors.Join(err, rollback(s, undo))
+	}
+	return undo, nil
+}
+
+// applyTx mutates s, recording each change in undo as it goes.
+func applyTx(s UTXOStore, tx TX, undo *TxUndo) error {
+	if tx.ID != tx.Hash() {
+		return fmt.Errorf("tx %s: %w", tx.ID, ErrIDMismatch)
+	}
+	if tx.IsCoinbase() {
+		return fmt.Errorf("tx %s: %w", tx.ID, ErrUnexpectedMint)
+	}
+	if err := CheckDoubleSpends([]TX{tx}); err != nil {
+		return err
+	}
+	// Check ownership & remove inputs
+	sighash := tx.SigHash()
+	var in uint64
+	for i, txin := range tx.Inputs {
+		key := UTXOKey{TxID: txin.PrevTxID, OutIndex: txin.OutputIndex}
+		out, exists, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("tx %s input %v: %w", tx.ID, key, ErrMissingInput)
 		}
-		delete(u, key)
+		if err := tx.verifyInput(i, out, sighash); err != nil {
+			return err
+		}
+		if in, err = addValue(in, out.Value); err != nil {
+			return fmt.Errorf("tx %s inputs: %w", tx.ID, err)
+		}
+		if err := s.Delete(key); err != nil {
+			return err
+		}
+		undo.Spent = append(undo.Spent, SpentOutput{Key: key, Output: out})
+	}
+	out, err := tx.outputSum()
+	if err != nil {
+		return fmt.Errorf("tx %s outputs: %w", tx.ID, err)
+	}
+	if out > in {
+		return fmt.Errorf("tx %s spends %d of %d: %w", tx.ID, out, in, ErrInsufficientInputs)
 	}
+	if err := addOutputs(s, tx, undo); err != nil {
+		return err
+	}
+	undo.Fee = in - out
+	return nil
+}
+
+func addOutputs(s UTXOStore, tx TX, undo *TxUndo) error {
 	for idx, out := range tx.Outputs {
 		key := UTXOKey{TxID: tx.ID, OutIndex: idx}
-		if _, exists := u[key]; exists {
+		_, exists, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if exists {
 			// Should never happen: Tx IDs must be unique
-			return errors.New("duplicate output key: " + fmt.Sprint(key))
+			return fmt.Errorf("tx %s: %w: %v", tx.ID, ErrDuplicateOutput, key)
+		}
+		if err := s.Put(key, out); err != nil {
+			return err
+		}
+		undo.Created = append(undo.Created, out)
+	}
+	return nil
+}
+
+// rollback reverses a possibly partial undo record without checks.
+func rollback(s UTXOStore, undo TxUndo) error {
+	for idx := range undo.Created {
+		if err := s.Delete(UTXOKey{TxID: undo.TxID, OutIndex: idx}); err != nil {
+			return err
+		}
+	}
+	for _, sp := range undo.Spent {
+		if err := s.Put(sp.Key, sp.Output); err != nil {
+			return err
 		}
-		u[key] = out
 	}
+	return nil
+}
+
+// RevertTx undoes a transaction previously applied with ApplyTx: its
+// outputs are removed and the outputs it spent are restored. It fails,
+// leaving s unchanged, if the outputs have since been spent or the inputs
+// have reappeared.
+func RevertTx(s UTXOStore, undo TxUndo) error {
+	for idx := range undo.Created {
+		key := UTXOKey{TxID: undo.TxID, OutIndex: idx}
+		_, ok, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("revert tx %s: output %v already spent", undo.TxID, key)
+		}
+	}
+	for _, sp := range undo.Spent {
+		_, ok, err := s.Get(sp.Key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("revert tx %s: input %v already present", undo.TxID, sp.Key)
+		}
+	}
+	return rollback(s, undo)
+}
+
+// ApplyBlock applies a block's transactions to s in order. If txs[0] is a
+// coinbase it may mint at most subsidy plus the fees of the others; its
+// outputs are added after every other transaction. ApplyBlock is
+// all-or-nothing: on error s is left unchanged.
+func ApplyBlock(s UTXOStore, txs []TX, subsidy uint64) (*BlockUndo, error) {
+	undo := &BlockUndo{}
+	if err := applyBlock(s, txs, subsidy, undo); err != nil {
+		for i := len(undo.Txs) - 1; i >= 0; i-- {
+			if rbErr := rollback(s, undo.Txs[i]); rbErr != nil {
+				return nil, errors.Join(err, rbErr)
+			}
+		}
+		return nil, err
+	}
+	return undo, nil
+}
+
+func applyBlock(s UTXOStore, txs []TX, subsidy uint64, undo *BlockUndo) error {
+	if err := CheckDoubleSpends(txs); err != nil {
+		return err
+	}
+	var coinbase *TX
+	if len(txs) > 0 && txs[0].IsCoinbase() {
+		coinbase, txs = &txs[0], txs[1:]
+	}
+	for _, tx := range txs {
+		undo.Txs = append(undo.Txs, TxUndo{TxID: tx.ID})
+		txu := &undo.Txs[len(undo.Txs)-1]
+		if err := applyTx(s, tx, txu); err != nil {
+			return err
+		}
+		var err error
+		if undo.Fees, err = addValue(undo.Fees, txu.Fee); err != nil {
+			return fmt.Errorf("block fees: %w", err)
+		}
+	}
+	if coinbase == nil {
+		return nil
+	}
+
+	if coinbase.ID != coinbase.Hash() {
+		return fmt.Errorf("tx %s: %w", coinbase.ID, ErrIDMismatch)
+	}
+	limit, err := addValue(subsidy, undo.Fees)
+	if err != nil {
+		return fmt.Errorf("coinbase limit: %w", err)
+	}
+	minted, err := coinbase.outputSum()
+	if err != nil {
+		return fmt.Errorf("tx %s outputs: %w", coinbase.ID, err)
+	}
+	if minted > limit {
+		return fmt.Errorf("tx %s mints %d, limit %d: %w",
+			coinbase.ID, minted, limit, ErrCoinbaseTooLarge)
+	}
+	undo.Txs = append(undo.Txs, TxUndo{TxID: coinbase.ID})
+	return addOutputs(s, *coinbase, &undo.Txs[len(undo.Txs)-1])
+}
 
+// UndoBlock reverts a block applied with ApplyBlock, transaction by
+// transaction in reverse order. On error the transactions already reverted
+// are re-applied so s is left unchanged.
+func UndoBlock(s UTXOStore, undo *BlockUndo) error {
+	for i := len(undo.Txs) - 1; i >= 0; i-- {
+		if err := RevertTx(s, undo.Txs[i]); err != nil {
+			for _, done := range undo.Txs[i+1:] {
+				if rdErr := redo(s, done); rdErr != nil {
+					return errors.Join(err, rdErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// redo re-applies a reverted transaction from its undo record.
+func redo(s UTXOStore, undo TxUndo) error {
+	for _, sp := range undo.Spent {
+		if err := s.Delete(sp.Key); err != nil {
+			return err
+		}
+	}
+	for idx, out := range undo.Created {
+		if err := s.Put(UTXOKey{TxID: undo.TxID, OutIndex: idx}, out); err != nil {
+			return err
+		}
+	}
 	return nil
 }
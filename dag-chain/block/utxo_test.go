@@ -1,6 +1,9 @@
 package block_test
 
 import (
+	"crypto/sha256"
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/Abdullah-zahoor/dagchain/block"
@@ -9,18 +12,14 @@ import (
 func TestApplyTx_Success(t *testing.T) {
 	utxo := make(block.UTXOSet)
 
-	// Mint a new coin
-	tx := block.TX{
-		ID:      "t1",
-		Inputs:  nil,
-		Outputs: []block.TXOutput{{Value: 10, Recipient: "Alice"}},
-	}
-	if err := utxo.ApplyTx(tx); err != nil {
+	// Mint a new coin through a block coinbase
+	tx := block.NewTX(nil, []block.TXOutput{{Value: 10, Recipient: "Alice"}}, nil)
+	if _, err := utxo.ApplyBlock([]block.TX{tx}, block.Subsidy(1)); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Expect the UTXO to contain that output
-	key := block.UTXOKey{TxID: "t1", OutIndex: 0}
+	key := block.UTXOKey{TxID: tx.ID, OutIndex: 0}
 	if out, ok := utxo[key]; !ok {
 		t.Error("expected utxo to contain new output")
 	} else if out.Value != 10 || out.Recipient != "Alice" {
@@ -28,26 +27,272 @@ func TestApplyTx_Success(t *testing.T) {
 	}
 }
 
+// testKey returns a deterministic key pair for name.
+func testKey(name string) *block.KeyPair {
+	seed := sha256.Sum256([]byte(name))
+	return block.KeyFromSeed(seed[:])
+}
+
 func TestApplyTx_DoubleSpend(t *testing.T) {
+	bob := testKey("bob")
 	utxo := make(block.UTXOSet)
 	// Prepare a UTXO to spend
-	utxo[block.UTXOKey{"t0", 0}] = block.TXOutput{Value: 5, Recipient: "Bob"}
+	utxo[block.UTXOKey{"t0", 0}] = block.TXOutput{Value: 5, Recipient: bob.Address()}
 
 	// First spend should succeed
-	tx1 := block.TX{
-		ID:     "t1",
-		Inputs: []block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
-	}
-	if err := utxo.ApplyTx(tx1); err != nil {
+	tx1 := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}}, nil, []byte("1"))
+	tx1.Sign(0, bob)
+	if _, err := utxo.ApplyTx(tx1); err != nil {
 		t.Fatalf("first spend failed: %v", err)
 	}
 
 	// Second spend of the same input should error
-	tx2 := block.TX{
-		ID:     "t2",
-		Inputs: []block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
-	}
-	if err := utxo.ApplyTx(tx2); err == nil {
+	tx2 := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}}, nil, []byte("2"))
+	tx2.Sign(0, bob)
+	if _, err := utxo.ApplyTx(tx2); err == nil {
 		t.Error("expected double‑spend error, got nil")
 	}
 }
+
+func TestApplyTx_IDMismatch(t *testing.T) {
+	utxo := make(block.UTXOSet)
+	tx := block.NewTX(nil, []block.TXOutput{{Value: 10, Recipient: "Alice"}}, nil)
+	tx.Outputs[0].Value = 1000 // tamper after the ID was fixed
+	if _, err := utxo.ApplyTx(tx); !errors.Is(err, block.ErrIDMismatch) {
+		t.Errorf("expected ErrIDMismatch, got %v", err)
+	}
+}
+
+func TestHash_Canonical(t *testing.T) {
+	a := block.NewTX([]block.TXInput{{PrevTxID: "ab", OutputIndex: 0}}, nil, nil)
+	b := block.NewTX([]block.TXInput{{PrevTxID: "a", OutputIndex: 0}}, []block.TXOutput{{Recipient: "b"}}, nil)
+	if a.ID == b.ID {
+		t.Error("distinct transactions share an ID")
+	}
+	if again := block.NewTX(a.Inputs, a.Outputs, nil); again.ID != a.ID {
+		t.Error("hash is not deterministic")
+	}
+}
+
+func TestApplyTx_Ownership(t *testing.T) {
+	bob, eve := testKey("bob"), testKey("eve")
+	prev := block.UTXOKey{TxID: "t0", OutIndex: 0}
+	spend := func() block.TX {
+		return block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
+			[]block.TXOutput{{Value: 5, Recipient: eve.Address()}}, nil)
+	}
+
+	cases := []struct {
+		name string
+		tx   func() block.TX
+		want error
+	}{
+		{"unsigned", spend, block.ErrMissingSignature},
+		{"wrong key", func() block.TX {
+			tx := spend()
+			tx.Sign(0, eve)
+			return tx
+		}, block.ErrWrongKey},
+		{"tampered", func() block.TX {
+			tx := spend()
+			tx.Sign(0, bob)
+			tx.Inputs[0].Signature[0] ^= 0xff
+			return tx
+		}, block.ErrBadSignature},
+	}
+	for _, c := range cases {
+		utxo := block.UTXOSet{prev: {Value: 5, Recipient: bob.Address()}}
+		_, err := utxo.ApplyTx(c.tx())
+		var sigErr *block.SignatureError
+		if !errors.Is(err, c.want) || !errors.As(err, &sigErr) || sigErr.Input != 0 {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func TestApplyTx_ValueConservation(t *testing.T) {
+	bob := testKey("bob")
+	prev := block.UTXOKey{TxID: "t0", OutIndex: 0}
+	spend := func(values ...uint64) block.TX {
+		outs := make([]block.TXOutput, len(values))
+		for i, v := range values {
+			outs[i] = block.TXOutput{Value: v, Recipient: bob.Address()}
+		}
+		tx := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}}, outs, nil)
+		tx.Sign(0, bob)
+		return tx
+	}
+
+	cases := []struct {
+		name string
+		tx   block.TX
+		want error
+	}{
+		{"overspend", spend(6), block.ErrInsufficientInputs},
+		{"overflow", spend(^uint64(0), 2), block.ErrValueOverflow},
+		{"mint", block.NewTX(nil, []block.TXOutput{{Value: 1}}, nil), block.ErrUnexpectedMint},
+		{"exact", spend(3, 2), nil},
+	}
+	for _, c := range cases {
+		utxo := block.UTXOSet{prev: {Value: 5, Recipient: bob.Address()}}
+		if _, err := utxo.ApplyTx(c.tx); !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func TestApplyBlock_CoinbaseLimit(t *testing.T) {
+	bob := testKey("bob")
+	pay := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
+		[]block.TXOutput{{Value: 7, Recipient: bob.Address()}}, nil)
+	pay.Sign(0, bob) // fee = 10 - 7 = 3
+	coinbase := func(v uint64) block.TX {
+		return block.NewTX(nil, []block.TXOutput{{Value: v, Recipient: bob.Address()}}, nil)
+	}
+
+	utxo := block.UTXOSet{{TxID: "t0", OutIndex: 0}: {Value: 10, Recipient: bob.Address()}}
+	if _, err := utxo.ApplyBlock([]block.TX{coinbase(54), pay}, 50); !errors.Is(err, block.ErrCoinbaseTooLarge) {
+		t.Errorf("expected ErrCoinbaseTooLarge, got %v", err)
+	}
+
+	utxo = block.UTXOSet{{TxID: "t0", OutIndex: 0}: {Value: 10, Recipient: bob.Address()}}
+	undo, err := utxo.ApplyBlock([]block.TX{coinbase(53), pay}, 50)
+	if err != nil || undo.Fees != 3 {
+		t.Fatalf("expected fees=3, got %+v, %v", undo, err)
+	}
+
+	// A mint anywhere but the first position is rejected
+	utxo = make(block.UTXOSet)
+	if _, err := utxo.ApplyBlock([]block.TX{coinbase(1), coinbase(2)}, 50); !errors.Is(err, block.ErrUnexpectedMint) {
+		t.Errorf("expected ErrUnexpectedMint, got %v", err)
+	}
+}
+
+func TestSubsidy(t *testing.T) {
+	if got := block.Subsidy(0); got != block.InitialSubsidy {
+		t.Errorf("Subsidy(0) = %d", got)
+	}
+	if got := block.Subsidy(block.HalvingInterval); got != block.InitialSubsidy/2 {
+		t.Errorf("Subsidy(HalvingInterval) = %d", got)
+	}
+	if got := block.Subsidy(64 * block.HalvingInterval); got != 0 {
+		t.Errorf("Subsidy after 64 halvings = %d", got)
+	}
+}
+
+func TestApplyTx_AtomicOnFailure(t *testing.T) {
+	bob := testKey("bob")
+	utxo := block.UTXOSet{{TxID: "t0", OutIndex: 0}: {Value: 5, Recipient: bob.Address()}}
+	before := utxo.Clone()
+
+	// Second input is missing: the first must not stay spent
+	tx := block.NewTX([]block.TXInput{
+		{PrevTxID: "t0", OutputIndex: 0},
+		{PrevTxID: "t0", OutputIndex: 1},
+	}, []block.TXOutput{{Value: 1, Recipient: bob.Address()}}, nil)
+	tx.Sign(0, bob)
+	tx.Sign(1, bob)
+	if _, err := utxo.ApplyTx(tx); err == nil {
+		t.Fatal("expected error for missing input")
+	}
+	if !reflect.DeepEqual(utxo, before) {
+		t.Errorf("set changed after failed apply: %v", utxo)
+	}
+}
+
+func TestApplyBlock_UndoBlock(t *testing.T) {
+	bob, eve := testKey("bob"), testKey("eve")
+	utxo := block.UTXOSet{{TxID: "t0", OutIndex: 0}: {Value: 10, Recipient: bob.Address()}}
+	before := utxo.Clone()
+
+	pay := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
+		[]block.TXOutput{{Value: 9, Recipient: eve.Address()}}, nil)
+	pay.Sign(0, bob)
+	onward := block.NewTX([]block.TXInput{{PrevTxID: pay.ID, OutputIndex: 0}},
+		[]block.TXOutput{{Value: 9, Recipient: bob.Address()}}, nil)
+	onward.Sign(0, eve)
+	coinbase := block.NewTX(nil, []block.TXOutput{{Value: 51, Recipient: bob.Address()}}, nil)
+
+	// A failing last tx rolls back the whole block
+	bad := block.NewTX([]block.TXInput{{PrevTxID: "missing", OutputIndex: 0}}, nil, nil)
+	if _, err := utxo.ApplyBlock([]block.TX{coinbase, pay, onward, bad}, 50); err == nil {
+		t.Fatal("expected block to fail")
+	}
+	if !reflect.DeepEqual(utxo, before) {
+		t.Fatalf("set changed after failed block: %v", utxo)
+	}
+
+	undo, err := utxo.ApplyBlock([]block.TX{coinbase, pay, onward}, 50)
+	if err != nil {
+		t.Fatalf("ApplyBlock failed: %v", err)
+	}
+	if len(utxo) != 2 {
+		t.Fatalf("expected coinbase and onward outputs, got %v", utxo)
+	}
+	if err := utxo.UndoBlock(undo); err != nil {
+		t.Fatalf("UndoBlock failed: %v", err)
+	}
+	if !reflect.DeepEqual(utxo, before) {
+		t.Errorf("set not restored by UndoBlock: %v", utxo)
+	}
+}
+
+func TestRevertTx_SpentOutput(t *testing.T) {
+	bob := testKey("bob")
+	utxo := block.UTXOSet{{TxID: "t0", OutIndex: 0}: {Value: 10, Recipient: bob.Address()}}
+	pay := block.NewTX([]block.TXInput{{PrevTxID: "t0", OutputIndex: 0}},
+		[]block.TXOutput{{Value: 10, Recipient: bob.Address()}}, nil)
+	pay.Sign(0, bob)
+	undo, err := utxo.ApplyTx(pay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(utxo, block.UTXOKey{TxID: pay.ID, OutIndex: 0}) // spent elsewhere
+	if err := utxo.RevertTx(undo); err == nil {
+		t.Error("expected revert of a spent output to fail")
+	}
+	if _, ok := utxo[block.UTXOKey{TxID: "t0", OutIndex: 0}]; ok {
+		t.Error("failed revert restored an input")
+	}
+}
+
+func TestDoubleSpend_Conflicts(t *testing.T) {
+	bob := testKey("bob")
+	prev := block.UTXOKey{TxID: "t0", OutIndex: 0}
+	in := block.TXInput{PrevTxID: "t0", OutputIndex: 0}
+	fresh := func() block.UTXOSet {
+		return block.UTXOSet{prev: {Value: 10, Recipient: bob.Address()}}
+	}
+
+	// Same input twice in one transaction
+	twice := block.NewTX([]block.TXInput{in, in}, nil, nil)
+	twice.Sign(0, bob)
+	twice.Sign(1, bob)
+	_, err := fresh().ApplyTx(twice)
+	var conflict *block.ConflictError
+	if !errors.Is(err, block.ErrDuplicateInput) || !errors.As(err, &conflict) {
+		t.Fatalf("expected ErrDuplicateInput, got %v", err)
+	}
+	if conflict.Outpoint != prev || conflict.FirstTx != twice.ID || conflict.SecondTx != twice.ID {
+		t.Errorf("wrong conflict details: %+v", conflict)
+	}
+
+	// Two transactions in one block spending the same output
+	a := block.NewTX([]block.TXInput{in}, nil, []byte("a"))
+	a.Sign(0, bob)
+	b := block.NewTX([]block.TXInput{in}, nil, []byte("b"))
+	b.Sign(0, bob)
+	_, err = fresh().ApplyBlock([]block.TX{a, b}, 50)
+	if !errors.Is(err, block.ErrDoubleSpend) || !errors.As(err, &conflict) {
+		t.Fatalf("expected ErrDoubleSpend, got %v", err)
+	}
+	if conflict.Outpoint != prev || conflict.FirstTx != a.ID || conflict.SecondTx != b.ID {
+		t.Errorf("wrong conflict details: %+v", conflict)
+	}
+
+	// A missing parent output is a different class
+	missing := block.NewTX([]block.TXInput{{PrevTxID: "nope", OutputIndex: 0}}, nil, nil)
+	if _, err := fresh().ApplyTx(missing); !errors.Is(err, block.ErrMissingInput) {
+		t.Errorf("expected ErrMissingInput, got %v", err)
+	}
+}
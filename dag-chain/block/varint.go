@@ -0,0 +1,107 @@
+package block
+
+import "encoding/binary"
+
+// AppendString appends s with a uvarint length, as Decoder.String reads it.
+// Unlike the canonical encoding this framing is compact and not hashed;
+// store records and p2p messages use it.
+func AppendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// AppendBytes appends p with a uvarint length, as Decoder.Bytes reads it.
+func AppendBytes(b, p []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// Decoder reads fields from a buffer. The first failure sticks: later
+// reads return zero values and Done reports ErrMalformed.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+// NewDecoder returns a Decoder reading b.
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{buf: b}
+}
+
+// Fail marks the input malformed, for checks the caller makes itself.
+func (d *Decoder) Fail() {
+	if d.err == nil {
+		d.err = ErrMalformed
+	}
+}
+
+// next returns the following n bytes, or nil if there are fewer.
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.Fail()
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// Byte reads a single byte.
+func (d *Decoder) Byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// Uint64 reads a fixed-width big-endian integer.
+func (d *Decoder) Uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// Uvarint reads what binary.AppendUvarint writes.
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.Fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// Count reads a uvarint length of items taking at least min bytes each,
+// bounded by what is left of the input, so a forged length cannot force
+// a huge allocation.
+func (d *Decoder) Count(min int) int {
+	n := d.Uvarint()
+	if d.err == nil && n > uint64(len(d.buf)/min) {
+		d.Fail()
+		return 0
+	}
+	return int(n)
+}
+
+// Bytes reads what AppendBytes writes. The result aliases the input.
+func (d *Decoder) Bytes() []byte {
+	return d.next(d.Count(1))
+}
+
+// String reads what AppendString writes.
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+// Done returns the first failure, or ErrMalformed if input is left over.
+func (d *Decoder) Done() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = ErrMalformed
+	}
+	return d.err
+}
@@ -1,33 +1,264 @@
 package consensus
 
-import "github.com/Abdullah-zahoor/dagchain/dag"
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
 
-// Finalized returns the IDs of all blocks that appear in the ancestor
-// sets of a strict majority of current tips.
-func Finalized(d *dag.DAG) []string {
-	tips := Tips(d)
-	if len(tips) == 0 {
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// FinalityRule picks a finality point: a block on the selected chain of
+// the heaviest tip whose past, itself included, is final. It returns nil
+// while nothing qualifies.
+type FinalityRule interface {
+	FinalityPoint(v *dag.View) *dag.Node
+}
+
+// Depth finalizes the selected-chain block N blocks below the heaviest tip.
+type Depth struct {
+	N int
+}
+
+func (r Depth) FinalityPoint(v *dag.View) *dag.Node {
+	n := v.HeaviestTip()
+	for i := 0; i < r.N && n != nil; i++ {
+		n = n.SelectedParent
+	}
+	return n
+}
+
+// BlueDepth finalizes the highest selected-chain block whose blue score is
+// at least Delta below the heaviest tip's. Blue scores come from the
+// GhostDAG merge rule; under any other rule they are all 0 and only a
+// Delta of 0 finalizes anything.
+type BlueDepth struct {
+	Delta uint64
+}
+
+func (r BlueDepth) FinalityPoint(v *dag.View) *dag.Node {
+	tip := v.HeaviestTip()
+	if tip == nil || tip.BlueScore < r.Delta {
+		return nil
+	}
+	n := tip
+	for n != nil && n.BlueScore > tip.BlueScore-r.Delta {
+		n = n.SelectedParent
+	}
+	return n
+}
+
+// Stake finalizes the highest selected-chain block that validators holding
+// more than two thirds of the stake have built on. A validator is known by
+// the address of its key, and its vote is the heaviest block it signed as
+// Producer; AddBlock has verified the signature. Anonymous blocks and
+// blocks from keys without stake are ignored, so neither spawning cheap
+// tips nor naming a staked address in a coinbase moves finality. Stakes
+// summing past 2^64 saturate.
+//
+// Votes are kept between calls: each call looks only at the blocks added
+// since the View it was last given, found by walking down from the tips
+// to blocks that View held. Use a Stake through a pointer, with one DAG.
+type Stake struct {
+	Stakes map[string]uint64 // address -> stake
+
+	mu    sync.Mutex
+	last  *dag.View
+	votes map[string]*dag.Node // address -> heaviest block it produced
+}
+
+func (r *Stake) FinalityPoint(v *dag.View) *dag.Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.catchUp(v)
+
+	type voter struct {
+		stake uint64
+		vote  *dag.Node
+		past  *dag.PastWalker
+		backs bool // the vote is on or above the chain block last checked
+	}
+	var total uint64
+	var voters []*voter
+	for addr, s := range r.Stakes {
+		total = addStake(total, s)
+		if vote := r.votes[addr]; vote != nil && s > 0 {
+			voters = append(voters, &voter{stake: s, vote: vote, past: dag.NewPastWalker(vote)})
+		}
+	}
+	// A block is only in the past of higher ones, so no chain block above
+	// the height reached by votes holding two thirds can qualify.
+	sort.Slice(voters, func(i, j int) bool { return voters[i].vote.Height > voters[j].vote.Height })
+	var reach, backed uint64
+	for _, vt := range voters {
+		if backed = addStake(backed, vt.stake); backed > twoThirds(total) {
+			reach = vt.vote.Height
+			break
+		}
+	}
+	if backed <= twoThirds(total) {
 		return nil
 	}
 
-	// count how many tips “confirm” each block
-	counts := make(map[string]int)
-	for _, tip := range tips {
-		as := ancestorSet(tip)
-		for id := range as {
-			counts[id]++
+	// Chain blocks come in decreasing height, and a vote backing one backs
+	// those below it, so each voter's past is walked at most once.
+	for n := v.HeaviestTip(); n != nil; n = n.SelectedParent {
+		if n.Height > reach {
+			continue
+		}
+		var backing uint64
+		for _, vt := range voters {
+			vt.backs = vt.backs || vt.past.Contains(n)
+			if vt.backs {
+				backing = addStake(backing, vt.stake)
+			}
+		}
+		if backing > twoThirds(total) {
+			return n
+		}
+	}
+	return nil
+}
+
+// catchUp counts the votes in the blocks of v that r.last lacks. Blocks
+// pruned from v are not walked into.
+func (r *Stake) catchUp(v *dag.View) {
+	if r.votes == nil {
+		r.votes = make(map[string]*dag.Node)
+	}
+	seen := make(map[*dag.Node]bool)
+	queue := v.Tips()
+	for len(queue) > 0 {
+		n := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[n] || v.Node(n.Block.ID) != n || (r.last != nil && r.last.Node(n.Block.ID) == n) {
+			continue
+		}
+		seen[n] = true
+		if addr, ok := producer(n); ok {
+			if cur := r.votes[addr]; cur == nil || dag.Heavier(n, cur) {
+				r.votes[addr] = n
+			}
 		}
+		queue = append(queue, n.Parents...)
+	}
+	r.last = v
+}
+
+// twoThirds returns floor(2t/3) without overflowing.
+func twoThirds(t uint64) uint64 {
+	return 2*(t/3) + 2*(t%3)/3
+}
+
+// addStake returns a+b, saturating at the largest uint64.
+func addStake(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+// producer returns the address of the key that signed n.
+func producer(n *dag.Node) (string, bool) {
+	if len(n.Block.Producer) == 0 {
+		return "", false
+	}
+	return block.Address(n.Block.Producer), true
+}
+
+// Finality tracks finalized blocks under a rule. The finality point only
+// moves forward: a point that does not have the current one in its past,
+// as after a reorg deeper than the rule allows, is ignored, so a block
+// once finalized stays finalized. It is safe for concurrent use.
+type Finality struct {
+	Rule FinalityRule
+
+	updating sync.Mutex // serializes Update so events go out in order
+	mu       sync.Mutex
+	point    *dag.Node
+	order    []*dag.Node
+	final    map[*dag.Node]bool
+}
+
+// NewFinality returns a tracker with nothing finalized yet.
+func NewFinality(rule FinalityRule) *Finality {
+	return &Finality{Rule: rule, final: make(map[*dag.Node]bool)}
+}
+
+// Update asks the rule for a new finality point in a View of d and returns
+// the blocks it newly finalizes, in topological order. They are also
+// published to d's subscribers as an EventFinalized, after f is unlocked
+// so that subscribers may query it.
+func (f *Finality) Update(d *dag.DAG) []*dag.Node {
+	f.updating.Lock()
+	defer f.updating.Unlock()
+	p := f.Rule.FinalityPoint(d.View())
+	added := f.finalize(p)
+	if len(added) > 0 {
+		d.Publish(dag.Event{Kind: dag.EventFinalized, Block: p, Nodes: added})
 	}
+	return added
+}
 
-	// need > 50% of tips
-	minCount := len(tips)/2 + 1
-	var finals []string
-	for id, cnt := range counts {
-		if cnt >= minCount {
-			finals = append(finals, id)
+// finalize moves the finality point to p, if it may, and returns the
+// blocks that newly become final.
+func (f *Finality) finalize(p *dag.Node) []*dag.Node {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p == nil || f.final[p] || (f.point != nil && !dag.IsAncestor(f.point, p)) {
+		return nil
+	}
+	// Walk p's selected chain down to the first final block; its whole
+	// past is final already. Emitting each chain block's merge set, then
+	// the block, from there up is ChainOrder without the final prefix, so
+	// the full list stays topological.
+	var chain []*dag.Node
+	for c := p; c != nil && !f.final[c]; c = c.SelectedParent {
+		chain = append(chain, c)
+	}
+	var added []*dag.Node
+	mark := func(n *dag.Node) {
+		if !f.final[n] {
+			f.final[n] = true
+			added = append(added, n)
 		}
 	}
-	return finals
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, n := range chain[i].MergeSet {
+			mark(n)
+		}
+		mark(chain[i])
+	}
+	f.point = p
+	f.order = append(f.order, added...)
+	return added
+}
+
+// Point returns the current finality point, or nil.
+func (f *Finality) Point() *dag.Node {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.point
 }
 
-// reuse the same ancestorSet helper from resolver.go
+// IsFinal reports whether n has been finalized.
+func (f *Finality) IsFinal(n *dag.Node) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.final[n]
+}
+
+// Finalized returns the IDs of all finalized blocks in the order they
+// were finalized, which is topological.
+func (f *Finality) Finalized() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, len(f.order))
+	for i, n := range f.order {
+		ids[i] = n.Block.ID
+	}
+	return ids
+}
@@ -0,0 +1,165 @@
+package consensus_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+func TestFinalizedDepth(t *testing.T) {
+	d, g, _, _ := makeSimpleDAG(t)
+	f := consensus.NewFinality(consensus.Depth{N: 1})
+	f.Update(d)
+	// both forks are one block above g
+	if final := f.Finalized(); len(final) != 1 || final[0] != g.ID {
+		t.Errorf("expected [%s], got %v", g.ID, final)
+	}
+}
+
+func TestFinalityQueriesDuringPublish(t *testing.T) {
+	d, g, _, _ := makeSimpleDAG(t)
+	f := consensus.NewFinality(consensus.Depth{N: 1})
+	sub := d.Subscribe(0)
+	defer sub.Unsubscribe()
+	go f.Update(d)
+	// Update waits for the event to be taken; f must still answer
+	point := make(chan *dag.Node)
+	go func() {
+		p := f.Point()
+		for ; p == nil; p = f.Point() {
+			time.Sleep(time.Millisecond)
+		}
+		point <- p
+	}()
+	select {
+	case p := <-point:
+		if p.Block.ID != g.ID {
+			t.Errorf("point %s, want %s", p.Block.ID, g.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Point blocked while EventFinalized was undelivered")
+	}
+	if ev := <-sub.C; ev.Kind != dag.EventFinalized || ev.Block.Block.ID != g.ID {
+		t.Errorf("got %v for %v", ev.Kind, ev.Block)
+	}
+}
+
+func TestFinalityIsMonotonic(t *testing.T) {
+	d, add := ghostDAG(t, 3)
+	a := d.Genesis()
+	for i := 0; i < 5; i++ {
+		a = add(a)
+	}
+	f := consensus.NewFinality(consensus.BlueDepth{Delta: 2})
+	if added := f.Update(d); len(added) != 4 {
+		t.Fatalf("finalized %d blocks, want genesis and 3 more", len(added))
+	}
+	before := f.Finalized()
+
+	// a longer branch from genesis takes over the heaviest tip
+	b := d.Genesis()
+	for i := 0; i < 8; i++ {
+		b = add(b)
+	}
+	if d.HeaviestTip() != b {
+		t.Fatal("expected the new branch to be heaviest")
+	}
+	if added := f.Update(d); added != nil {
+		t.Errorf("reorg finalized %d blocks off the finalized chain", len(added))
+	}
+	if !reflect.DeepEqual(f.Finalized(), before) {
+		t.Error("finalized blocks changed after a reorg")
+	}
+}
+
+func TestStakeFinality(t *testing.T) {
+	d, add := ghostDAG(t, 3)
+	var keys [3]*block.KeyPair
+	stakes := make(map[string]uint64)
+	for i := range keys {
+		seed := sha256.Sum256([]byte{byte(i)})
+		keys[i] = block.KeyFromSeed(seed[:])
+		stakes[keys[i].Address()] = 1
+	}
+	// produce returns a block on parent signed by key, or anonymous with a
+	// nil key, whose coinbase pays addr
+	produce := func(parent *dag.Node, key *block.KeyPair, addr string, nonce byte) *dag.Node {
+		t.Helper()
+		cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: addr}}, []byte{nonce})
+		blk := block.NewBlock([]string{parent.Block.ID}, []block.TX{cb}, parent.Block.Timestamp.Add(1))
+		if key != nil {
+			blk.Producer = key.Public
+			blk.ID = blk.Hash()
+			blk.Sign(key)
+		}
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return d.Node(blk.ID)
+	}
+	x1 := produce(d.Genesis(), keys[0], "a", 1)
+	x2 := produce(x1, keys[1], "b", 2)
+	x3 := produce(x2, keys[2], "c", 3)
+
+	f := consensus.NewFinality(&consensus.Stake{Stakes: stakes})
+	f.Update(d)
+	// only x1 is built on by all three validators
+	if got := f.Point(); got != x1 {
+		t.Fatalf("finality point %v, want x1", got)
+	}
+	// a vote added since the last update counts
+	produce(x3, keys[0], "a", 4)
+	f.Update(d)
+	if got := f.Point(); got != x2 {
+		t.Fatalf("finality point %v, want x2", got)
+	}
+
+	// unsigned blocks outgrow the chain without moving finality, even
+	// with coinbases paying the staked addresses
+	spam := d.Genesis()
+	for i := 0; i < 6; i++ {
+		spam = produce(spam, nil, keys[i%3].Address(), byte(10+i))
+	}
+	add(spam)
+	if added := f.Update(d); added != nil || f.Point() != x2 {
+		t.Errorf("unsigned blocks moved finality to %s", f.Point().Block.ID)
+	}
+
+	// naming a staked key as producer without its signature is invalid
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "d"}}, []byte{99})
+	forged := block.NewBlock([]string{spam.Block.ID}, []block.TX{cb}, spam.Block.Timestamp.Add(1))
+	forged.Producer = keys[0].Public
+	forged.ID = forged.Hash()
+	thief := sha256.Sum256([]byte("thief"))
+	forged.Sign(block.KeyFromSeed(thief[:]))
+	if err := d.AddBlock(forged); !errors.Is(err, block.ErrBadSignature) {
+		t.Errorf("forged producer: got %v, want ErrBadSignature", err)
+	}
+}
+
+func TestStakeSaturates(t *testing.T) {
+	d, _ := ghostDAG(t, 3)
+	seed := sha256.Sum256([]byte("whale"))
+	key := block.KeyFromSeed(seed[:])
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "w"}}, nil)
+	blk := block.NewBlock([]string{d.Genesis().Block.ID}, []block.TX{cb}, d.Genesis().Block.Timestamp.Add(1))
+	blk.Producer = key.Public
+	blk.ID = blk.Hash()
+	blk.Sign(key)
+	if err := d.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	// without saturation the total wraps to 1 and the minor holder alone
+	// would be a two-thirds majority
+	rule := &consensus.Stake{Stakes: map[string]uint64{key.Address(): math.MaxUint64, "minor": 2}}
+	if p := rule.FinalityPoint(d.View()); p == nil || p.Block.ID != blk.ID {
+		t.Errorf("finality point %v, want the whale's block", p)
+	}
+}
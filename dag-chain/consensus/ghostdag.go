@@ -0,0 +1,128 @@
+package consensus
+
+import (
+	"sort"
+
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// GhostDAG is the GHOSTDAG (PHANTOM) merge rule. Each block colors its
+// merge set: a block is blue if the blues stay a k-cluster, meaning no
+// blue has more than K blues in its anticone, and red otherwise. Parents
+// are selected by blue work, then blue score, and merge sets are ordered
+// by blue score, which gives every block the same total order of its
+// past. Blue work is 0 without proof of work.
+//
+// Set it as the DAG's merge rule before adding any block after genesis:
+//
+//	d.Merge = consensus.GhostDAG{K: 18}
+type GhostDAG struct {
+	K int
+}
+
+// SelectParent picks the parent with the highest blue work, then blue
+// score, ties to the lowest ID.
+func (GhostDAG) SelectParent(parents []*dag.Node) *dag.Node {
+	var best *dag.Node
+	for _, p := range parents {
+		if best == nil || bluer(p, best) {
+			best = p
+		}
+	}
+	return best
+}
+
+func bluer(a, b *dag.Node) bool {
+	if a.BlueWork != b.BlueWork {
+		return a.BlueWork > b.BlueWork
+	}
+	if a.BlueScore != b.BlueScore {
+		return a.BlueScore > b.BlueScore
+	}
+	return a.Block.ID < b.Block.ID
+}
+
+// Order sorts by blue score, then ID. A block's blue score is above each
+// of its parents', so the order is topological.
+func (GhostDAG) Order(mergeset []*dag.Node) {
+	sort.Slice(mergeset, func(i, j int) bool {
+		a, b := mergeset[i], mergeset[j]
+		if a.BlueScore != b.BlueScore {
+			return a.BlueScore < b.BlueScore
+		}
+		return a.Block.ID < b.Block.ID
+	})
+}
+
+// Color colors an ordered merge set for a new block with the given
+// selected parent. The selected parent is always blue; each other block is
+// colored in order against the blues chosen so far.
+func (g GhostDAG) Color(selected *dag.Node, mergeset []*dag.Node) *dag.Coloring {
+	c := &dag.Coloring{
+		Blues:         []*dag.Node{selected},
+		BluesAnticone: map[*dag.Node]int{selected: 0},
+	}
+	for _, candidate := range mergeset {
+		if anticone, ok := g.blue(selected, c, candidate); ok {
+			c.Blues = append(c.Blues, candidate)
+			c.BluesAnticone[candidate] = len(anticone)
+			for b, size := range anticone {
+				c.BluesAnticone[b] = size + 1
+			}
+		} else {
+			c.Reds = append(c.Reds, candidate)
+		}
+	}
+	c.BlueScore = selected.BlueScore + uint64(len(c.Blues))
+	return c
+}
+
+// blue reports whether candidate can join the new block's blues, and if
+// so returns the blues in its anticone with their current anticone sizes.
+// It walks the selected-parent chain, starting with the new block itself,
+// until reaching a chain block in candidate's past: every blue from there
+// on is in candidate's past too.
+func (g GhostDAG) blue(selected *dag.Node, c *dag.Coloring, candidate *dag.Node) (map[*dag.Node]int, bool) {
+	if len(c.Blues) > g.K {
+		return nil, false
+	}
+	anticone := make(map[*dag.Node]int)
+	blues := c.Blues
+	for chain := selected; ; chain = chain.SelectedParent {
+		for _, b := range blues {
+			if dag.IsAncestor(b, candidate) {
+				continue
+			}
+			size := blueAnticoneSize(b, selected, c)
+			anticone[b] = size
+			if len(anticone) > g.K || size == g.K {
+				return nil, false
+			}
+		}
+		if chain == nil || dag.IsAncestor(chain, candidate) {
+			return anticone, true
+		}
+		blues = chain.Blues
+	}
+}
+
+// blueAnticoneSize returns the size of b's blue anticone as seen by the
+// new block, taken from the first chain block that colored b blue.
+func blueAnticoneSize(b, selected *dag.Node, c *dag.Coloring) int {
+	if size, ok := c.BluesAnticone[b]; ok {
+		return size
+	}
+	for chain := selected; chain != nil; chain = chain.SelectedParent {
+		if size, ok := chain.BluesAnticone[b]; ok {
+			return size
+		}
+	}
+	return 0
+}
+
+// TotalOrder returns every block in the DAG in consensus order: the order
+// a block with all current tips as parents would see. With GhostDAG as the
+// merge rule this is the GHOSTDAG ordering.
+func TotalOrder(d *dag.DAG) []*dag.Node {
+	return d.Order(Tips(d))
+}
@@ -0,0 +1,159 @@
+package consensus_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// ghostDAG returns a DAG using GHOSTDAG with the given k, and an add
+// helper that builds an empty block on parents with a unique timestamp.
+func ghostDAG(t *testing.T, k int) (*dag.DAG, func(parents ...*dag.Node) *dag.Node) {
+	t.Helper()
+	d := dag.NewDAG()
+	d.Merge = consensus.GhostDAG{K: k}
+	ts := time.Unix(1700000000, 0)
+	if err := d.AddGenesis(block.NewBlock(nil, nil, ts), block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	add := func(parents ...*dag.Node) *dag.Node {
+		t.Helper()
+		ids := make([]string, len(parents))
+		for i, p := range parents {
+			ids[i] = p.Block.ID
+		}
+		ts = ts.Add(time.Second)
+		blk := block.NewBlock(ids, nil, ts)
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return d.Node(blk.ID)
+	}
+	return d, add
+}
+
+func TestGhostDAGChainBlueScore(t *testing.T) {
+	d, add := ghostDAG(t, 3)
+	n := d.Genesis()
+	for i := 1; i <= 5; i++ {
+		n = add(n)
+		if n.BlueScore != uint64(i) {
+			t.Fatalf("block %d: blue score %d, want %d", i, n.BlueScore, i)
+		}
+		if len(n.Blues) != 1 || n.Blues[0] != n.SelectedParent || len(n.Reds) != 0 {
+			t.Fatalf("block %d: blues %d reds %d, want only the selected parent", i, len(n.Blues), len(n.Reds))
+		}
+	}
+}
+
+func TestGhostDAGColoring(t *testing.T) {
+	for _, tc := range []struct {
+		k         int
+		wantScore uint64
+		wantReds  int
+	}{
+		{k: 0, wantScore: 2, wantReds: 1},
+		{k: 1, wantScore: 3, wantReds: 0},
+	} {
+		d, add := ghostDAG(t, tc.k)
+		a, b := add(d.Genesis()), add(d.Genesis())
+		m := add(a, b)
+		if m.BlueScore != tc.wantScore || len(m.Reds) != tc.wantReds {
+			t.Errorf("k=%d: blue score %d with %d reds, want %d with %d",
+				tc.k, m.BlueScore, len(m.Reds), tc.wantScore, tc.wantReds)
+		}
+		if want := (consensus.GhostDAG{}).SelectParent([]*dag.Node{a, b}); m.SelectedParent != want {
+			t.Errorf("k=%d: selected parent is not the lowest ID", tc.k)
+		}
+	}
+}
+
+func TestTotalOrderIsTopological(t *testing.T) {
+	d, add := ghostDAG(t, 2)
+	rng := rand.New(rand.NewSource(1))
+	nodes := []*dag.Node{d.Genesis()}
+	for i := 0; i < 60; i++ {
+		// pick up to three parents among the most recent blocks
+		seen := map[*dag.Node]bool{}
+		var parents []*dag.Node
+		for j := 0; j < 1+rng.Intn(3); j++ {
+			p := nodes[max(0, len(nodes)-1-rng.Intn(6))]
+			if !seen[p] {
+				seen[p] = true
+				parents = append(parents, p)
+			}
+		}
+		nodes = append(nodes, add(parents...))
+	}
+
+	order := consensus.TotalOrder(d)
+	if len(order) != d.Len() {
+		t.Fatalf("order has %d blocks, DAG has %d", len(order), d.Len())
+	}
+	pos := make(map[*dag.Node]int, len(order))
+	for i, n := range order {
+		if _, dup := pos[n]; dup {
+			t.Fatalf("block %s appears twice", n.Block.ID)
+		}
+		pos[n] = i
+	}
+	for _, n := range order {
+		for _, p := range n.Parents {
+			if pos[p] >= pos[n] {
+				t.Fatalf("parent %s ordered after child %s", p.Block.ID, n.Block.ID)
+			}
+		}
+	}
+}
+
+func TestBlueWorkOutranksBlueScore(t *testing.T) {
+	d := dag.NewDAG()
+	d.Merge = consensus.GhostDAG{K: 3}
+	d.Params = dag.Params{ProofOfWork: true, MinDifficulty: 1, TargetSpacing: time.Second, DifficultyWindow: 4}
+	ts := time.Unix(1700000000, 0)
+	if err := d.AddGenesis(block.NewBlock(nil, nil, ts), block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	mine := func(parent *dag.Node, at time.Time) *dag.Node {
+		t.Helper()
+		blk := block.NewBlock([]string{parent.Block.ID}, nil, at)
+		blk.Difficulty = d.NextDifficulty([]*dag.Node{parent})
+		if !blk.Mine(1 << 24) {
+			t.Fatal("no nonce found")
+		}
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return d.Node(blk.ID)
+	}
+
+	// fast blocks push the difficulty up
+	trunk := d.Genesis()
+	for trunk.Block.Difficulty < 64 {
+		ts = ts.Add(250 * time.Millisecond)
+		trunk = mine(trunk, ts)
+	}
+	// two blocks on schedule, against four whose timestamps spread out to
+	// cut their difficulty
+	honest, cheap := trunk, trunk
+	for i := 1; i <= 2; i++ {
+		honest = mine(honest, ts.Add(time.Duration(i)*time.Second))
+	}
+	for i := 1; i <= 4; i++ {
+		cheap = mine(cheap, ts.Add(time.Duration(i)*time.Minute))
+	}
+	if cheap.BlueScore <= honest.BlueScore || cheap.BlueWork >= honest.BlueWork {
+		t.Fatalf("want more blues but less work on the cheap branch: blue score %d vs %d, blue work %d vs %d",
+			cheap.BlueScore, honest.BlueScore, cheap.BlueWork, honest.BlueWork)
+	}
+	if d.HeaviestTip() != honest {
+		t.Error("the branch with fewer blocks but more work should be heaviest")
+	}
+	if d.Merge.SelectParent([]*dag.Node{cheap, honest}) != honest {
+		t.Error("a block merging both should select the honest branch")
+	}
+}
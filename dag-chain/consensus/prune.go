@@ -0,0 +1,194 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// Archive keeps blocks pruned from the DAG so they can be reconnected.
+type Archive interface {
+	Put(blk *block.Block) error
+	Get(id string) (*block.Block, bool, error)
+	Delete(id string) error
+}
+
+// MemArchive is an in-memory Archive.
+type MemArchive map[string]*block.Block
+
+func (a MemArchive) Put(blk *block.Block) error {
+	a[blk.ID] = blk
+	return nil
+}
+
+func (a MemArchive) Get(id string) (*block.Block, bool, error) {
+	blk, ok := a[id]
+	return blk, ok, nil
+}
+
+func (a MemArchive) Delete(id string) error {
+	delete(a, id)
+	return nil
+}
+
+// Pruned is the result of Prune.
+type Pruned struct {
+	Blocks []*block.Block // moved to the archive, in topological order
+	// Orphaned holds the non-coinbase transactions of Blocks, in order.
+	// Some may be confirmed by kept blocks too; mempool.Readmit skips
+	// those.
+	Orphaned []block.TX
+}
+
+// Prune moves the side branches that lost to the finality point into
+// archive. A block is pruned when it is neither final nor in the past of
+// any block built on the finality point: it forked off below that point
+// and nothing above it has merged it. Blocks above the finality point are
+// never pruned, so branches there can still overtake each other. Nothing
+// is pruned while f has no finality point. The branches are found in a
+// View of d; if a block arrives on one before it is removed, Prune stops
+// with an error, leaving the blocks not yet removed in the DAG and out of
+// the archive. The result lists only the blocks removed.
+func Prune(d *dag.DAG, f *Finality, archive Archive) (*Pruned, error) {
+	point := f.Point()
+	if point == nil {
+		return &Pruned{}, nil
+	}
+	v := d.View()
+
+	// keep future(point) and everything it has merged
+	keep := map[*dag.Node]bool{point: true}
+	future := []*dag.Node{point}
+	for i := 0; i < len(future); i++ {
+		for _, c := range v.Children(future[i]) {
+			if !keep[c] {
+				keep[c] = true
+				future = append(future, c)
+			}
+		}
+	}
+	walkParents(future, func(n *dag.Node) bool {
+		if keep[n] || f.IsFinal(n) {
+			return false
+		}
+		keep[n] = true
+		return true
+	})
+
+	// everything else not final lies below a tip outside keep
+	seen := make(map[*dag.Node]bool)
+	var pruned []*dag.Node
+	for _, tip := range v.Tips() {
+		if keep[tip] {
+			continue
+		}
+		seen[tip] = true
+		pruned = append(pruned, tip)
+		walkParents([]*dag.Node{tip}, func(n *dag.Node) bool {
+			if keep[n] || f.IsFinal(n) || seen[n] {
+				return false
+			}
+			seen[n] = true
+			pruned = append(pruned, n)
+			return true
+		})
+	}
+	dag.HeightOrder{}.Order(pruned)
+
+	for _, n := range pruned {
+		if err := archive.Put(n.Block); err != nil {
+			return nil, fmt.Errorf("archive block %s: %w", n.Block.ID, err)
+		}
+	}
+	// descendants of a pruned block are pruned too, so going from the
+	// highest down only ever removes tips, unless a block arrived on the
+	// branch since v was taken
+	i := len(pruned)
+	var err error
+	for ; i > 0; i-- {
+		if _, err = d.RemoveTip(pruned[i-1].Block.ID); err != nil {
+			err = fmt.Errorf("prune: %w", err)
+			break
+		}
+	}
+	for _, n := range pruned[:i] {
+		if derr := archive.Delete(n.Block.ID); derr != nil {
+			err = errors.Join(err, derr)
+		}
+	}
+	pruned = pruned[i:]
+
+	res := &Pruned{}
+	for _, n := range pruned {
+		res.Blocks = append(res.Blocks, n.Block)
+		for _, tx := range n.Block.TXs {
+			if !tx.IsCoinbase() {
+				res.Orphaned = append(res.Orphaned, tx)
+			}
+		}
+	}
+	if len(pruned) > 0 {
+		d.Publish(dag.Event{Kind: dag.EventPruned, Nodes: pruned})
+	}
+	return res, err
+}
+
+// walkParents visits the ancestors of from breadth first. visit reports
+// whether to continue below a node.
+func walkParents(from []*dag.Node, visit func(*dag.Node) bool) {
+	queue := append([]*dag.Node(nil), from...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, p := range n.Parents {
+			if visit(p) {
+				queue = append(queue, p)
+			}
+		}
+	}
+}
+
+// Reconnect adds an archived block back to the DAG along with any of its
+// archived ancestors, parents first, and removes them from the archive.
+// It is how a block arriving on top of a pruned branch gets its parents
+// back. The reconnected blocks are returned in the order they were added.
+func Reconnect(d *dag.DAG, archive Archive, id string) ([]*block.Block, error) {
+	var order []*block.Block
+	seen := make(map[string]bool)
+	var visit func(id string) error
+	visit = func(id string) error {
+		if d.Node(id) != nil || seen[id] {
+			return nil
+		}
+		seen[id] = true
+		blk, ok, err := archive.Get(id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("block %s is neither in the DAG nor archived", id)
+		}
+		for _, pid := range blk.Parents {
+			if err := visit(pid); err != nil {
+				return err
+			}
+		}
+		order = append(order, blk)
+		return nil
+	}
+	if err := visit(id); err != nil {
+		return nil, err
+	}
+
+	for i, blk := range order {
+		if err := d.AddBlock(blk); err != nil {
+			return order[:i], fmt.Errorf("reconnect: %w", err)
+		}
+		if err := archive.Delete(blk.ID); err != nil {
+			return order[:i+1], fmt.Errorf("reconnect: %w", err)
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,114 @@
+package consensus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+func TestPruneBelowFinality(t *testing.T) {
+	d, _, f1, f2 := makeSimpleDAG(t)
+	// extend fork2 with a coinbase block to make it heavier
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, nil)
+	f3 := block.NewBlock([]string{f2.ID}, []block.TX{cb}, time.Unix(1700000003, 0))
+	if err := d.AddBlock(f3); err != nil {
+		t.Fatal(err)
+	}
+	archive := make(consensus.MemArchive)
+
+	// nothing is pruned before anything is final
+	f := consensus.NewFinality(consensus.Depth{N: 1})
+	if pr, err := consensus.Prune(d, f, archive); err != nil || len(pr.Blocks) != 0 {
+		t.Fatalf("pruned %v (err %v) without a finality point", pr, err)
+	}
+
+	f.Update(d) // f2 is final
+	pr, err := consensus.Prune(d, f, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Blocks) != 1 || pr.Blocks[0].ID != f1.ID {
+		t.Fatalf("pruned %d blocks, want only f1", len(pr.Blocks))
+	}
+	if d.Node(f1.ID) != nil {
+		t.Error("f1 should have been pruned")
+	}
+	if _, ok := archive[f1.ID]; !ok {
+		t.Error("f1 should have been archived")
+	}
+	if tips := consensus.Tips(d); len(tips) != 1 || tips[0].Block.ID != f3.ID {
+		t.Errorf("tips after pruning: %v, want only f3", tips)
+	}
+
+	// a block built on the pruned branch brings it back
+	back, err := consensus.Reconnect(d, archive, f1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != 1 || d.Node(f1.ID) == nil || len(archive) != 0 {
+		t.Errorf("reconnect restored %d blocks, archive left with %d", len(back), len(archive))
+	}
+}
+
+func TestPruneOrphansAndReorgAboveFinality(t *testing.T) {
+	seed := []byte("prune-test-key-0123456789abcdef!")
+	key := block.KeyFromSeed(seed)
+	utxo := block.NewUTXOTrie()
+	utxo.Put(block.UTXOKey{TxID: "funding"}, block.TXOutput{Value: 10, Recipient: key.Address()})
+
+	d := dag.NewDAG()
+	d.Merge = consensus.GhostDAG{K: 3}
+	ts := time.Unix(1700000000, 0)
+	if err := d.AddGenesis(block.NewBlock(nil, nil, ts), utxo); err != nil {
+		t.Fatal(err)
+	}
+	add := func(parent *dag.Node, txs ...block.TX) *dag.Node {
+		t.Helper()
+		ts = ts.Add(time.Second)
+		blk := block.NewBlock([]string{parent.Block.ID}, txs, ts)
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return d.Node(blk.ID)
+	}
+
+	spend := block.NewTX([]block.TXInput{{PrevTxID: "funding"}}, []block.TXOutput{{Value: 10, Recipient: "x"}}, nil)
+	spend.Sign(0, key)
+	add(d.Genesis(), spend) // side branch forking below finality
+
+	a := []*dag.Node{d.Genesis()}
+	for i := 1; i <= 4; i++ {
+		a = append(a, add(a[i-1]))
+	}
+	b3 := add(a[2]) // fork above finality
+
+	f := consensus.NewFinality(consensus.Depth{N: 2})
+	f.Update(d)
+	if f.Point() != a[2] {
+		t.Fatal("expected a2 as the finality point")
+	}
+	pr, err := consensus.Prune(d, f, make(consensus.MemArchive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Blocks) != 1 || len(pr.Orphaned) != 1 || pr.Orphaned[0].ID != spend.ID {
+		t.Fatalf("pruned %d blocks orphaning %d txs, want the side block and its spend", len(pr.Blocks), len(pr.Orphaned))
+	}
+	if d.Node(b3.Block.ID) == nil {
+		t.Fatal("branch above the finality point was pruned")
+	}
+
+	// the branch above finality overtakes the old chain
+	b5 := add(add(b3))
+	if d.HeaviestTip() != b5 {
+		t.Fatal("expected b5 to be the heaviest tip")
+	}
+	r := dag.FindReorg(a[4], b5)
+	if r.Fork != a[2] || len(r.Disconnected) != 2 || r.Disconnected[0] != a[3] ||
+		len(r.Connected) != 3 || r.Connected[0] != b3 || r.Connected[2] != b5 {
+		t.Errorf("unexpected reorg: fork %v, %d disconnected, %d connected", r.Fork, len(r.Disconnected), len(r.Connected))
+	}
+}
@@ -0,0 +1,108 @@
+package dag_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// benchAddBlock measures AddBlock on a DAG whose genesis holds size
+// outputs. Every block merges the two previous blocks and mints a coinbase,
+// so each insert snapshots a parent and merges the other.
+func benchAddBlock(b *testing.B, size int, genesisUTXO block.UTXOStore) {
+	for i := 0; i < size; i++ {
+		genesisUTXO.Put(block.UTXOKey{TxID: fmt.Sprintf("g%d", i)}, block.TXOutput{Value: 1})
+	}
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, genesisUTXO); err != nil {
+		b.Fatal(err)
+	}
+	prev := []string{gen.ID}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte(fmt.Sprint(i)))
+		blk := block.NewBlock(prev, []block.TX{cb}, ts.Add(time.Duration(i+1)))
+		if err := d.AddBlock(blk); err != nil {
+			b.Fatal(err)
+		}
+		prev = []string{prev[len(prev)-1], blk.ID}
+	}
+}
+
+func BenchmarkAddBlock(b *testing.B) {
+	for _, size := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("map-clone/%d", size), func(b *testing.B) {
+			benchAddBlock(b, size, make(block.UTXOSet))
+		})
+		b.Run(fmt.Sprintf("trie/%d", size), func(b *testing.B) {
+			benchAddBlock(b, size, block.NewUTXOTrie())
+		})
+	}
+}
+
+// wideDAG builds a DAG of n empty blocks in eight lanes, each block
+// extending its lane and every third one merging the next lane too.
+func wideDAG(b *testing.B, n int) *dag.DAG {
+	b.Helper()
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		b.Fatal(err)
+	}
+	lanes := make([]string, 8)
+	for i := range lanes {
+		lanes[i] = gen.ID
+	}
+	for i := 0; i < n; i++ {
+		l := i % len(lanes)
+		parents := []string{lanes[l]}
+		if next := lanes[(l+1)%len(lanes)]; i%3 == 0 && next != lanes[l] {
+			parents = append(parents, next)
+		}
+		blk := block.NewBlock(parents, nil, ts.Add(time.Duration(i+1)))
+		if err := d.AddBlock(blk); err != nil {
+			b.Fatal(err)
+		}
+		lanes[l] = blk.ID
+	}
+	return d
+}
+
+func BenchmarkTips(b *testing.B) {
+	const size = 100_000
+	d := wideDAG(b, size)
+	b.Run(fmt.Sprintf("HeaviestTip/%d", size), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if d.HeaviestTip() == nil {
+				b.Fatal("no tip")
+			}
+		}
+	})
+	b.Run(fmt.Sprintf("Tips/%d", size), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if len(d.Tips()) == 0 {
+				b.Fatal("no tips")
+			}
+		}
+	})
+	// what the simulator does per block: pick the heaviest tip, extend it
+	b.Run(fmt.Sprintf("extend-heaviest/%d", size), func(b *testing.B) {
+		ts := time.Unix(1800000000, 0)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tip := d.HeaviestTip()
+			blk := block.NewBlock([]string{tip.Block.ID}, nil, ts.Add(time.Duration(i)))
+			if err := d.AddBlock(blk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,92 +1,236 @@
 package dag
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/bits"
 
 	"github.com/Abdullah-zahoor/dagchain/block"
 )
 
+// ErrMissingParent is returned by AddBlock when a parent is not in the DAG.
+var ErrMissingParent = errors.New("parent not found")
+
+// ErrNoState is returned by MergeParents, and so AddBlock, when the
+// selected parent was restored without its UTXO state.
+var ErrNoState = errors.New("selected parent's state was not kept")
+
 // NewDAG initializes an empty DAG.
 func NewDAG() *DAG {
-	return &DAG{
-		Nodes: make(map[string]*Node),
-	}
+	return &DAG{index: newNodeIndex()}
+}
+
+// Node returns the node for a block ID, or nil if it is not in the DAG.
+func (d *DAG) Node(id string) *Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.index.node(id)
+}
+
+// Len returns the number of blocks in the DAG.
+func (d *DAG) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.index.len()
+}
+
+// Genesis returns the genesis node, or nil before AddGenesis.
+func (d *DAG) Genesis() *Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.genesis
+}
+
+// Children returns n's current children. The slice must not be modified.
+func (d *DAG) Children(n *Node) []*Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.index.children(n)
 }
 
 // AddGenesis seeds the DAG with a genesis block and its starting UTXO set.
-func (d *DAG) AddGenesis(genesis *block.Block, initialUTXO block.UTXOSet) error {
+// The DAG takes ownership of initialUTXO, wrapping it in a
+// block.CommittedStore; every other node's state is a Snapshot descended
+// from it, so its implementation decides where the DAG keeps UTXO data. A
+// *block.UTXOTrie lets nodes share unchanged state. If genesis carries a
+// UTXORoot, it must commit to initialUTXO.
+func (d *DAG) AddGenesis(genesis *block.Block, initialUTXO block.UTXOStore) error {
+	d.write.Lock()
+	defer d.write.Unlock()
 	if len(genesis.Parents) != 0 {
 		return fmt.Errorf("genesis block must have no parents")
 	}
+	if genesis.ID != genesis.Hash() {
+		return fmt.Errorf("genesis %s: %w", genesis.ID, block.ErrIDMismatch)
+	}
+	if err := genesis.CheckTxRoot(); err != nil {
+		return err
+	}
+	state, err := block.Commit(initialUTXO)
+	if err != nil {
+		return fmt.Errorf("genesis %s: commit utxo set: %w", genesis.ID, err)
+	}
+	if root := state.Root(); genesis.UTXORoot != "" && genesis.UTXORoot != root {
+		detail := fmt.Sprintf("header has %s, set gives %s", genesis.UTXORoot, root)
+		return &RuleError{BlockID: genesis.ID, Err: ErrUTXORootMismatch, Detail: detail}
+	}
 	node := &Node{
-		Block:    genesis,
-		Parents:  nil,
-		Children: nil,
-		Weight:   uint64(len(genesis.TXs)),
-		UTXO:     initialUTXO.Clone(),
+		Block:   genesis,
+		Parents: nil,
+		Weight:  d.Params.work(genesis),
+		UTXO:    state,
 	}
-	d.Nodes[genesis.ID] = node
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.index.put(indexItem{node: node})
+	d.genesis = node
+	d.tips.add(node)
 	return nil
 }
 
 // AddBlock inserts blk into the DAG, links it, computes its UTXO snapshot & weight.
+// Validation runs without blocking readers; other writers wait.
 func (d *DAG) AddBlock(blk *block.Block) error {
-	// 1. Gather parents
+	d.write.Lock()
+	defer d.write.Unlock()
+
+	// 0. The ID must commit to the block's content, which must be well formed
+	if blk.ID != blk.Hash() {
+		return fmt.Errorf("block %s: %w", blk.ID, block.ErrIDMismatch)
+	}
+	if err := blk.CheckTxRoot(); err != nil {
+		return err
+	}
+	if err := blk.CheckSignature(); err != nil {
+		return err
+	}
+	if d.Node(blk.ID) != nil {
+		return fmt.Errorf("block %s already in DAG", blk.ID)
+	}
+	if err := d.Params.checkContent(blk); err != nil {
+		return err
+	}
+
+	// 1. Gather parents and check the block against d.Params
 	parents := make([]*Node, 0, len(blk.Parents))
 	for _, pid := range blk.Parents {
-		p, ok := d.Nodes[pid]
-		if !ok {
-			return fmt.Errorf("parent %s not found", pid)
+		p := d.Node(pid)
+		if p == nil {
+			return fmt.Errorf("block %s: %w: %s", blk.ID, ErrMissingParent, pid)
 		}
 		parents = append(parents, p)
 	}
+	if err := d.Params.checkParents(blk, parents, d.mergeRule().SelectParent(parents)); err != nil {
+		return err
+	}
 
-	// 2. INTERSECTION‑merge parent UTXOs
-	var merged block.UTXOSet
-	if len(parents) == 0 {
-		merged = make(block.UTXOSet)
-	} else {
-		// start from first parent's snapshot
-		merged = parents[0].UTXO.Clone()
-		// drop any UTXO not present in *every* parent
-		for _, p := range parents[1:] {
-			for key := range merged {
-				if _, ok := p.UTXO[key]; !ok {
-					delete(merged, key)
-				}
-			}
-		}
+	// 2. Merge parent UTXOs: selected parent's state plus the merge set
+	m, err := d.MergeParents(parents)
+	if err != nil {
+		return fmt.Errorf("block %s: merge parents: %w", blk.ID, err)
 	}
 
-	// 3. Validate & apply TXs inline
-	for _, tx := range blk.TXs {
-		if err := merged.ApplyTx(tx); err != nil {
-			return fmt.Errorf("block %s has invalid tx %s: %w",
-				blk.ID, tx.ID, err)
+	// 3. Height = max(parent.Height) + 1; it drives the subsidy schedule
+	var height uint64
+	for _, p := range parents {
+		if p.Height+1 > height {
+			height = p.Height + 1
 		}
 	}
 
-	// 4. Compute weight = max(parent.Weight) + len(TXs)
+	// 4. Validate & apply TXs inline; the block's own TXs must all apply
+	if _, err := block.ApplyBlock(m.State, blk.TXs, block.Subsidy(height)); err != nil {
+		return fmt.Errorf("block %s has invalid tx: %w", blk.ID, err)
+	}
+	if err := d.Params.checkUTXORoot(blk, m.State); err != nil {
+		return err
+	}
+
+	// 5. Compute weight = max(parent.Weight) + work, saturating
 	var maxW uint64
 	for _, p := range parents {
 		if p.Weight > maxW {
 			maxW = p.Weight
 		}
 	}
-	weight := maxW + uint64(len(blk.TXs))
+	weight, carry := bits.Add64(maxW, d.Params.work(blk), 0)
+	if carry != 0 {
+		weight = math.MaxUint64
+	}
 
-	// 5. Create the new node and link it
+	// 6. Create the new node and link it
 	newNode := &Node{
-		Block:    blk,
-		Parents:  parents,
-		Children: nil,
-		Weight:   weight,
-		UTXO:     merged,
+		Block:          blk,
+		Parents:        parents,
+		Weight:         weight,
+		Height:         height,
+		UTXO:           m.State,
+		SelectedParent: m.Selected,
+		MergeSet:       m.MergeSet,
+		Rejected:       m.Rejected,
 	}
+	if c := m.Coloring; c != nil {
+		newNode.BlueScore = c.BlueScore
+		if d.Params.ProofOfWork {
+			newNode.BlueWork = blueWork(blk, m.Selected, c.Blues)
+		}
+		newNode.Blues = c.Blues
+		newNode.Reds = c.Reds
+		newNode.BluesAnticone = c.BluesAnticone
+	}
+	d.mu.Lock()
+	prevTip := d.tips.heaviest()
 	for _, p := range parents {
-		p.Children = append(p.Children, newNode)
+		d.index.addChild(p, newNode)
+		d.tips.remove(p)
 	}
-	d.Nodes[blk.ID] = newNode
+	d.index.put(indexItem{node: newNode})
+	d.tips.add(newNode)
+	tip := d.tips.heaviest()
+	d.mu.Unlock()
+
+	// 7. Notify subscribers, still holding d.write so events stay in order
+	d.Publish(Event{Kind: EventBlockAdded, Block: newNode})
+	d.publishTip(prevTip, tip)
 
 	return nil
 }
+
+// Restore links a node rebuilt from storage, as by store.BlockStore,
+// without validating it: the caller vouches for the block and for the
+// consensus data it fills in. Its parents must be in the DAG already, and
+// a node without parents becomes the genesis. UTXO may be nil for a node
+// whose state was not kept; blocks selecting it as parent then fail with
+// ErrNoState. Nothing is published to subscribers.
+func (d *DAG) Restore(n *Node) error {
+	d.write.Lock()
+	defer d.write.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := n.Block.ID
+	if d.index.node(id) != nil {
+		return fmt.Errorf("block %s already in DAG", id)
+	}
+	if len(n.Parents) != len(n.Block.Parents) {
+		return fmt.Errorf("block %s: %d parents linked, header lists %d", id, len(n.Parents), len(n.Block.Parents))
+	}
+	for i, p := range n.Parents {
+		if p.Block.ID != n.Block.Parents[i] || d.index.node(p.Block.ID) != p {
+			return fmt.Errorf("block %s: %w: %s", id, ErrMissingParent, n.Block.Parents[i])
+		}
+	}
+	if len(n.Parents) == 0 {
+		if d.genesis != nil {
+			return fmt.Errorf("block %s: genesis already set", id)
+		}
+		d.genesis = n
+	}
+	for _, p := range n.Parents {
+		d.index.addChild(p, n)
+		d.tips.remove(p)
+	}
+	d.index.put(indexItem{node: n})
+	d.tips.add(n)
+	return nil
+}
@@ -1,37 +1,40 @@
 package dag_test
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Abdullah-zahoor/dagchain/block"
 	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/store"
 )
 
 func TestAddGenesisAndBlock(t *testing.T) {
 	d := dag.NewDAG()
 
 	// Add genesis
-	gen := &block.Block{ID: "g", Parents: nil, TXs: nil, Timestamp: time.Now()}
+	gen := block.NewBlock(nil, nil, time.Now())
 	if err := d.AddGenesis(gen, make(block.UTXOSet)); err != nil {
 		t.Fatalf("AddGenesis failed: %v", err)
 	}
 
 	// Add a child block with one TX
-	tx := block.TX{
-		ID:      "tx",
-		Inputs:  nil,
-		Outputs: []block.TXOutput{{Value: 1, Recipient: "X"}},
-	}
-	b := &block.Block{ID: "b", Parents: []string{"g"}, TXs: []block.TX{tx}, Timestamp: time.Now()}
+	tx := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "X"}}, nil)
+	b := block.NewBlock([]string{gen.ID}, []block.TX{tx}, time.Now())
 	if err := d.AddBlock(b); err != nil {
 		t.Fatalf("AddBlock failed: %v", err)
 	}
 
 	// Check parent‑child links
-	parent := d.Nodes["g"]
-	child := d.Nodes["b"]
-	if len(parent.Children) != 1 || parent.Children[0] != child {
+	parent := d.Node(gen.ID)
+	child := d.Node(b.ID)
+	if len(d.Children(parent)) != 1 || d.Children(parent)[0] != child {
 		t.Error("child link missing")
 	}
 	if len(child.Parents) != 1 || child.Parents[0] != parent {
@@ -43,3 +46,456 @@ func TestAddGenesisAndBlock(t *testing.T) {
 		t.Errorf("expected weight=1, got %d", child.Weight)
 	}
 }
+
+func TestAddBlock_MissingParent(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, make(block.UTXOSet)); err != nil {
+		t.Fatal(err)
+	}
+	absent := block.NewBlock([]string{gen.ID}, nil, ts.Add(time.Second))
+	err := d.AddBlock(block.NewBlock([]string{absent.ID}, nil, ts.Add(2*time.Second)))
+	if !errors.Is(err, dag.ErrMissingParent) {
+		t.Fatalf("expected ErrMissingParent, got %v", err)
+	}
+}
+
+func TestAddBlock_RejectsForgedID(t *testing.T) {
+	d := dag.NewDAG()
+	gen := block.NewBlock(nil, nil, time.Now())
+	if err := d.AddGenesis(gen, make(block.UTXOSet)); err != nil {
+		t.Fatalf("AddGenesis failed: %v", err)
+	}
+
+	b := block.NewBlock([]string{gen.ID}, nil, time.Now())
+	b.Timestamp = b.Timestamp.Add(time.Second) // content no longer matches ID
+	if err := d.AddBlock(b); !errors.Is(err, block.ErrIDMismatch) {
+		t.Errorf("expected ErrIDMismatch, got %v", err)
+	}
+}
+
+func TestAddBlock_LogStore(t *testing.T) {
+	utxo, err := store.OpenLogStore(filepath.Join(t.TempDir(), "utxo.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer utxo.Close()
+
+	d := dag.NewDAG()
+	gen := block.NewBlock(nil, nil, time.Now())
+	if err := d.AddGenesis(gen, utxo); err != nil {
+		t.Fatalf("AddGenesis failed: %v", err)
+	}
+	tx := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "X"}}, nil)
+	b := block.NewBlock([]string{gen.ID}, []block.TX{tx}, time.Now())
+	if err := d.AddBlock(b); err != nil {
+		t.Fatalf("AddBlock failed: %v", err)
+	}
+	out, ok, err := d.Node(b.ID).UTXO.Get(block.UTXOKey{TxID: tx.ID, OutIndex: 0})
+	if err != nil || !ok || out.Value != 1 {
+		t.Errorf("coinbase output missing: %+v, %v, %v", out, ok, err)
+	}
+	if utxo.Len() != 0 {
+		t.Error("child block wrote into the genesis state")
+	}
+}
+
+func TestAddBlock_MergeKeepsBothBranches(t *testing.T) {
+	seed := sha256.Sum256([]byte("alice"))
+	alice := block.KeyFromSeed(seed[:])
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	genUTXO := block.NewUTXOTrie()
+	coin := block.UTXOKey{TxID: "premine", OutIndex: 0}
+	genUTXO.Put(coin, block.TXOutput{Value: 10, Recipient: alice.Address()})
+	if err := d.AddGenesis(gen, genUTXO); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two branches each mint a coinbase and spend the same premined coin
+	branch := func(name string, at time.Duration) (*block.Block, block.TX, block.TX) {
+		cb := block.NewTX(nil, []block.TXOutput{{Value: 50, Recipient: name}}, nil)
+		spend := block.NewTX([]block.TXInput{{PrevTxID: coin.TxID, OutputIndex: 0}},
+			[]block.TXOutput{{Value: 10, Recipient: name}}, nil)
+		spend.Sign(0, alice)
+		b := block.NewBlock([]string{gen.ID}, []block.TX{cb, spend}, ts.Add(at))
+		if err := d.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		return b, cb, spend
+	}
+	a, cbA, spendA := branch("bob", time.Second)
+	b, cbB, spendB := branch("carol", 2*time.Second)
+
+	m := block.NewBlock([]string{a.ID, b.ID}, nil, ts.Add(3*time.Second))
+	if err := d.AddBlock(m); err != nil {
+		t.Fatalf("merge block rejected: %v", err)
+	}
+	node := d.Node(m.ID)
+
+	// Equal weight: the lower ID is selected and wins the conflict
+	winner, loser := spendA, spendB
+	loserBlock := b
+	if b.ID < a.ID {
+		winner, loser, loserBlock = spendB, spendA, a
+	}
+	for _, tx := range []block.TX{cbA, cbB, winner} {
+		if _, ok, _ := node.UTXO.Get(block.UTXOKey{TxID: tx.ID}); !ok {
+			t.Errorf("merged state lost output of %s", tx.ID)
+		}
+	}
+	if _, ok, _ := node.UTXO.Get(block.UTXOKey{TxID: loser.ID}); ok {
+		t.Error("conflicting spend was applied")
+	}
+	if len(node.Rejected) != 1 || node.Rejected[0].TxID != loser.ID ||
+		node.Rejected[0].BlockID != loserBlock.ID || !errors.Is(node.Rejected[0].Err, block.ErrMissingInput) {
+		t.Errorf("expected %s reported as rejected, got %+v", loser.ID, node.Rejected)
+	}
+	if len(node.MergeSet) != 1 || node.MergeSet[0].Block.ID != loserBlock.ID {
+		t.Errorf("unexpected merge set %v", node.MergeSet)
+	}
+}
+
+// scanTips finds the tips the slow way, for comparison with d.Tips.
+func scanTips(d *dag.DAG) map[*dag.Node]bool {
+	tips := make(map[*dag.Node]bool)
+	v := d.View()
+	for _, n := range v.Nodes() {
+		if len(v.Children(n)) == 0 {
+			tips[n] = true
+		}
+	}
+	return tips
+}
+
+func TestTipsFollowAddAndRemove(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	check := func(step string) {
+		t.Helper()
+		want := scanTips(d)
+		got := d.Tips()
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d tips, want %d", step, len(got), len(want))
+		}
+		for i, n := range got {
+			if !want[n] {
+				t.Fatalf("%s: %s is not a tip", step, n.Block.ID)
+			}
+			if i > 0 && dag.Heavier(n, got[i-1]) {
+				t.Fatalf("%s: tips not heaviest first", step)
+			}
+		}
+		if d.HeaviestTip() != got[0] {
+			t.Fatalf("%s: HeaviestTip is not the first tip", step)
+		}
+	}
+
+	// three lanes that cross-merge now and then
+	lanes := []string{gen.ID, gen.ID, gen.ID}
+	var added []string
+	for i := 0; i < 30; i++ {
+		parents := []string{lanes[i%3]}
+		if i%4 == 0 && lanes[(i+1)%3] != lanes[i%3] {
+			parents = append(parents, lanes[(i+1)%3])
+		}
+		var txs []block.TX
+		if i%5 == 0 {
+			txs = []block.TX{block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte{byte(i)})}
+		}
+		blk := block.NewBlock(parents, txs, ts.Add(time.Duration(i+1)*time.Second))
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		lanes[i%3] = blk.ID
+		added = append(added, blk.ID)
+		check("add")
+	}
+
+	if _, err := d.RemoveTip(gen.ID); err == nil {
+		t.Fatal("removed a block with children")
+	}
+	full := d.View()
+	for i := len(added) - 1; i >= 0; i-- {
+		if _, err := d.RemoveTip(added[i]); err != nil {
+			t.Fatal(err)
+		}
+		check("remove")
+	}
+	if tips := d.Tips(); len(tips) != 1 || tips[0].Block.ID != gen.ID {
+		t.Fatal("genesis should be the only tip left")
+	}
+	// a view taken before the removals still holds every block and link
+	if full.Len() != len(added)+1 || len(full.Children(full.Genesis)) == 0 {
+		t.Fatalf("old view changed: %d blocks, genesis has %d children", full.Len(), len(full.Children(full.Genesis)))
+	}
+	for _, id := range added {
+		if full.Node(id) == nil {
+			t.Fatalf("old view lost %s", id)
+		}
+	}
+}
+
+func TestEventsReportTipChangesAndReorgs(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	sub := d.Subscribe(16)
+	defer sub.Unsubscribe()
+	// add builds a block on parent, with a coinbase if ntx is 1
+	add := func(parent string, ntx int) *block.Block {
+		t.Helper()
+		ts = ts.Add(time.Second)
+		var txs []block.TX
+		if ntx == 1 {
+			txs = []block.TX{block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte(ts.String()))}
+		}
+		blk := block.NewBlock([]string{parent}, txs, ts)
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return blk
+	}
+	expect := func(kinds ...dag.EventKind) []dag.Event {
+		t.Helper()
+		var evs []dag.Event
+		for _, k := range kinds {
+			select {
+			case ev := <-sub.C:
+				if ev.Kind != k {
+					t.Fatalf("got %v, want %v", ev.Kind, k)
+				}
+				evs = append(evs, ev)
+			default:
+				t.Fatalf("no event, want %v", k)
+			}
+		}
+		select {
+		case ev := <-sub.C:
+			t.Fatalf("unexpected %v", ev.Kind)
+		default:
+		}
+		return evs
+	}
+
+	a := add(gen.ID, 0)
+	expect(dag.EventBlockAdded, dag.EventTipChanged)
+	// a heavier sibling takes over
+	b1 := add(gen.ID, 1)
+	evs := expect(dag.EventBlockAdded, dag.EventReorg)
+	r := evs[1].Reorg
+	if evs[1].PrevTip.Block.ID != a.ID || evs[1].Block.Block.ID != b1.ID {
+		t.Error("reorg event has the wrong tips")
+	}
+	if r.Fork.Block.ID != gen.ID || len(r.Disconnected) != 1 || r.Disconnected[0].Block.ID != a.ID ||
+		len(r.Connected) != 1 || r.Connected[0].Block.ID != b1.ID {
+		t.Errorf("reorg lists %d disconnected, %d connected blocks", len(r.Disconnected), len(r.Connected))
+	}
+	// extending the tip is not a reorg
+	add(b1.ID, 1)
+	expect(dag.EventBlockAdded, dag.EventTipChanged)
+	// a lighter fork does not move the tip
+	add(gen.ID, 0)
+	expect(dag.EventBlockAdded)
+}
+
+func TestUnsubscribeReleasesBlockedPublisher(t *testing.T) {
+	d := dag.NewDAG()
+	gen := block.NewBlock(nil, nil, time.Unix(1700000000, 0))
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	sub := d.Subscribe(0)
+	done := make(chan error)
+	go func() {
+		done <- d.AddBlock(block.NewBlock([]string{gen.ID}, nil, time.Unix(1700000001, 0)))
+	}()
+	// the publisher waits for the first event to be taken
+	if ev := <-sub.C; ev.Kind != dag.EventBlockAdded {
+		t.Fatalf("got %v first", ev.Kind)
+	}
+	select {
+	case <-done:
+		t.Fatal("AddBlock returned while an event was undelivered")
+	case <-time.After(20 * time.Millisecond):
+	}
+	sub.Unsubscribe()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, open := <-sub.C; open {
+		t.Error("C should be closed after Unsubscribe")
+	}
+}
+
+// TestConcurrentReadersAndWriters is meant for the race detector: writers
+// extend the DAG while readers take views, build on tips and follow
+// events, and every view must be closed under parents and children.
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	sub := d.Subscribe(0)
+	go func() {
+		for ev := range sub.C {
+			d.View()
+			_ = ev.Block.Block.ID
+		}
+	}()
+
+	const writers, blocks = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < blocks; i++ {
+				tip := d.HeaviestTip()
+				mint := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte(fmt.Sprintf("%d-%d", w, i)))
+				blk := block.NewBlock([]string{tip.Block.ID}, []block.TX{mint}, ts.Add(time.Duration(i)*time.Second))
+				if err := d.AddBlock(blk); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v := d.View()
+				for _, n := range v.Nodes() {
+					for _, p := range n.Parents {
+						if v.Node(p.Block.ID) != p {
+							t.Errorf("view has %s without its parent", n.Block.ID)
+						}
+					}
+					for _, c := range v.Children(n) {
+						if v.Node(c.Block.ID) != c {
+							t.Errorf("view has a child of %s it does not hold", n.Block.ID)
+						}
+					}
+				}
+				for _, tip := range v.Tips() {
+					if len(v.Children(tip)) != 0 {
+						t.Errorf("tip %s has children in the view", tip.Block.ID)
+					}
+				}
+				if _, err := d.MergeParents(d.Tips()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	sub.Unsubscribe()
+	if got, want := d.Len(), 1+writers*blocks; got != want {
+		t.Errorf("DAG has %d blocks, want %d", got, want)
+	}
+}
+
+func TestLocatorAndAntiPast(t *testing.T) {
+	d := dag.NewDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, make(block.UTXOSet)); err != nil {
+		t.Fatal(err)
+	}
+	add := func(n int, parents ...string) *block.Block {
+		t.Helper()
+		tx := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "X"}}, []byte{byte(n)})
+		b := block.NewBlock(parents, []block.TX{tx}, ts.Add(time.Duration(n)*time.Second))
+		if err := d.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	chain := []*block.Block{gen}
+	for i := 1; i <= 30; i++ {
+		chain = append(chain, add(i, chain[i-1].ID))
+	}
+	side := add(100, chain[20].ID)
+
+	loc := d.Locator()
+	// ten single steps, then doubling: heights 30..21, 19, 15, 7, genesis
+	want := []int{30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 19, 15, 7, 0}
+	if len(loc) != len(want) {
+		t.Fatalf("locator has %d entries, want %d", len(loc), len(want))
+	}
+	for i, h := range want {
+		if loc[i] != chain[h].ID {
+			t.Errorf("locator[%d] is not the block at height %d", i, h)
+		}
+	}
+
+	// a peer knowing up to height 25 lacks 26..30 and the side block
+	v := d.View()
+	fork := v.FindFork([]string{"unknown", chain[25].ID, gen.ID})
+	if fork.Block.ID != chain[25].ID {
+		t.Fatal("fork is not the first known locator block")
+	}
+	missing := v.AntiPast(fork)
+	if len(missing) != 6 || missing[0].Block.ID != side.ID {
+		t.Fatalf("anti-past has %d blocks, want 6 starting with the side block", len(missing))
+	}
+	for i := 1; i < len(missing); i++ {
+		if missing[i].Height < missing[i-1].Height {
+			t.Error("anti-past not in height order")
+		}
+	}
+	if v.FindFork([]string{"unknown"}) != v.Genesis {
+		t.Error("fork without a shared block is not genesis")
+	}
+
+	// from any fork, the walk matches the definition, page by page
+	merge := add(101, side.ID, chain[30].ID)
+	v = d.View()
+	for _, f := range []*block.Block{gen, chain[10], chain[20], chain[25], side, merge} {
+		fork := v.Node(f.ID)
+		var want []string
+		for _, n := range v.AntiPast(fork) {
+			want = append(want, n.Block.ID)
+		}
+		var brute int
+		for _, n := range v.Nodes() {
+			if n != fork && !dag.IsAncestor(n, fork) {
+				brute++
+			}
+		}
+		var got []string
+		w := v.WalkAntiPast(fork)
+		for page := w.Next(4); len(page) > 0; page = w.Next(4) {
+			for _, n := range page {
+				got = append(got, n.Block.ID)
+			}
+		}
+		if len(want) != brute || !slices.Equal(got, want) {
+			t.Errorf("fork at %s: paged %d blocks, AntiPast %d, want %d", f.ID, len(got), len(want), brute)
+		}
+	}
+}
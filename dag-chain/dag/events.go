@@ -0,0 +1,148 @@
+package dag
+
+import "sync"
+
+// EventKind says what happened to the DAG.
+type EventKind int
+
+const (
+	EventBlockAdded EventKind = iota // Block was added
+	EventTipChanged                  // the heaviest tip moved from PrevTip to Block
+	EventReorg                       // like EventTipChanged, but off PrevTip's selected chain
+	EventFinalized                   // Nodes became final, in topological order
+	EventPruned                      // Nodes were pruned, in topological order
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventBlockAdded:
+		return "BlockAdded"
+	case EventTipChanged:
+		return "TipChanged"
+	case EventReorg:
+		return "Reorg"
+	case EventFinalized:
+		return "Finalized"
+	case EventPruned:
+		return "Pruned"
+	}
+	return "EventKind(?)"
+}
+
+// Event is a change to the DAG. Fields not used by the kind are nil.
+type Event struct {
+	Kind    EventKind
+	Block   *Node  // added block, new heaviest tip, or new finality point
+	PrevTip *Node  // heaviest tip before a TipChanged or Reorg
+	Reorg   *Reorg // chain blocks that left and joined, for EventReorg
+	Nodes   []*Node
+}
+
+// Subscription receives events on C, in the order they were published.
+type Subscription struct {
+	C <-chan Event
+
+	ch   chan Event
+	bus  *bus
+	done chan struct{}
+	once sync.Once
+}
+
+// Unsubscribe stops delivery and closes C. It unblocks a publisher
+// waiting on this subscriber.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.mu.Lock()
+		defer s.bus.mu.Unlock()
+		delete(s.bus.subs, s)
+		close(s.ch)
+	})
+}
+
+// bus fans events out to subscribers. Delivery blocks until every
+// subscriber has room, so a slow consumer slows the DAG instead of
+// missing events.
+type bus struct {
+	mu   sync.Mutex // held for a whole delivery, so all see one order
+	subs map[*Subscription]struct{}
+}
+
+// Subscribe returns a subscription buffering up to buffer events. Once
+// the buffer is full, changes to the DAG wait for the subscriber, so it
+// must keep reading C or Unsubscribe, and must not wait on a change to
+// the DAG itself. Reading the DAG while handling an event is fine.
+func (d *DAG) Subscribe(buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, bus: &d.bus, done: make(chan struct{})}
+	d.bus.mu.Lock()
+	defer d.bus.mu.Unlock()
+	if d.bus.subs == nil {
+		d.bus.subs = make(map[*Subscription]struct{})
+	}
+	d.bus.subs[s] = struct{}{}
+	return s
+}
+
+// Publish delivers ev to every subscriber. The DAG publishes its own
+// changes; consensus code publishes finality and pruning.
+func (d *DAG) Publish(ev Event) {
+	d.bus.mu.Lock()
+	defer d.bus.mu.Unlock()
+	for s := range d.bus.subs {
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		}
+	}
+}
+
+// publishTip reports a move of the heaviest tip from prev to tip.
+func (d *DAG) publishTip(prev, tip *Node) {
+	if tip == prev || tip == nil || prev == nil {
+		return
+	}
+	r := FindReorg(prev, tip)
+	if len(r.Disconnected) == 0 {
+		d.Publish(Event{Kind: EventTipChanged, Block: tip, PrevTip: prev})
+		return
+	}
+	d.Publish(Event{Kind: EventReorg, Block: tip, PrevTip: prev, Reorg: r})
+}
+
+// Reorg describes a change of the heaviest tip in terms of selected
+// chains: the blocks that left the chain and those that joined it, both
+// from the fork point up.
+type Reorg struct {
+	Fork         *Node
+	Disconnected []*Node
+	Connected    []*Node
+}
+
+// FindReorg compares the selected chains of two tips. A tip extending the
+// other yields no disconnected blocks.
+func FindReorg(from, to *Node) *Reorg {
+	r := &Reorg{}
+	a, b := from, to
+	for a != b {
+		// step down whichever side is higher; the selected chain of a
+		// block only holds lower blocks
+		if b == nil || (a != nil && a.Height >= b.Height) {
+			r.Disconnected = append(r.Disconnected, a)
+			a = a.SelectedParent
+		} else {
+			r.Connected = append(r.Connected, b)
+			b = b.SelectedParent
+		}
+	}
+	r.Fork = a
+	reverse(r.Disconnected)
+	reverse(r.Connected)
+	return r
+}
+
+func reverse(ns []*Node) {
+	for i, j := 0, len(ns)-1; i < j; i, j = i+1, j-1 {
+		ns[i], ns[j] = ns[j], ns[i]
+	}
+}
@@ -0,0 +1,80 @@
+package dag
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"github.com/Abdullah-zahoor/dagchain/internal/hamt"
+)
+
+// nodeIndex maps block IDs to nodes and their children. It is persistent:
+// a snapshot shares every trie node with the index and never changes, so
+// a View holds the index as of one moment for the cost of copying a
+// pointer.
+type nodeIndex struct {
+	m *hamt.Map[string, indexItem]
+}
+
+// indexItem is a node and its children as of one version of the index.
+// The children slice is never appended to in place.
+type indexItem struct {
+	node     *Node
+	children []*Node
+}
+
+func newNodeIndex() nodeIndex {
+	return nodeIndex{m: hamt.New[string, indexItem](hashID)}
+}
+
+func hashID(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+func (x nodeIndex) len() int {
+	return x.m.Len()
+}
+
+func (x nodeIndex) lookup(id string) (indexItem, bool) {
+	return x.m.Get(id)
+}
+
+// node returns the node for id, or nil.
+func (x nodeIndex) node(id string) *Node {
+	it, _ := x.m.Get(id)
+	return it.node
+}
+
+// children returns n's children, or nil if n is not in the index.
+func (x nodeIndex) children(n *Node) []*Node {
+	if it, ok := x.m.Get(n.Block.ID); ok && it.node == n {
+		return it.children
+	}
+	return nil
+}
+
+// put adds it, or replaces the item for the same ID.
+func (x nodeIndex) put(it indexItem) {
+	x.m.Put(it.node.Block.ID, it)
+}
+
+// addChild appends c to p's children.
+func (x nodeIndex) addChild(p, c *Node) {
+	it, _ := x.m.Get(p.Block.ID)
+	x.put(indexItem{node: p, children: append(slices.Clip(it.children), c)})
+}
+
+func (x nodeIndex) remove(id string) {
+	x.m.Delete(id)
+}
+
+// snapshot returns an index that later writes to x do not change.
+func (x nodeIndex) snapshot() nodeIndex {
+	return nodeIndex{m: x.m.Snapshot()}
+}
+
+// each visits the nodes in hash order until fn returns false.
+func (x nodeIndex) each(fn func(*Node) bool) {
+	x.m.Each(func(_ string, it indexItem) bool { return fn(it.node) })
+}
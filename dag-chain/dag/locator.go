@@ -0,0 +1,152 @@
+package dag
+
+import "container/heap"
+
+// Locator summarizes the selected chain of the heaviest tip for a peer to
+// find where its DAG and ours part: the IDs of the ten highest chain
+// blocks, then of chain blocks at doubling distances further down, always
+// ending with genesis.
+func (d *DAG) Locator() []string {
+	var ids []string
+	step := 1
+	n := d.HeaviestTip()
+	for n != nil {
+		ids = append(ids, n.Block.ID)
+		if n.SelectedParent == nil {
+			return ids
+		}
+		if len(ids) >= 10 {
+			step *= 2
+		}
+		for i := 0; i < step && n.SelectedParent != nil; i++ {
+			n = n.SelectedParent
+		}
+	}
+	return ids
+}
+
+// FindFork returns the first block of locator in the view, the highest
+// chain block a peer sending it shares with us. Without any, it returns
+// genesis.
+func (v *View) FindFork(locator []string) *Node {
+	for _, id := range locator {
+		if n := v.Node(id); n != nil {
+			return n
+		}
+	}
+	return v.Genesis
+}
+
+// AntiPast returns the view's blocks that are neither n nor in its past,
+// ordered by height, then ID, so parents come before children. They are
+// what a peer whose DAG holds n and its past may be missing.
+func (v *View) AntiPast(n *Node) []*Node {
+	return v.WalkAntiPast(n).Next(v.Len())
+}
+
+// AntiPastWalk hands out a View's anti-past of a block in the order of
+// AntiPast, a page at a time, so a long answer is not rebuilt per page.
+type AntiPastWalk struct {
+	v        *View
+	frontier chainHeap // next blocks to hand out, possibly repeated
+	last     *Node
+}
+
+// WalkAntiPast starts a walk over the anti-past of n. It visits the
+// blocks above n's lowest anticone block, not the whole DAG: ones as high
+// as n or higher are never in its past, and lower ones are told apart
+// by walking down from the tips and from n at once, highest first, so
+// that each block's descendants are settled before it is.
+func (v *View) WalkAntiPast(n *Node) *AntiPastWalk {
+	past := map[*Node]bool{n: true} // block -> in n's past or n itself
+	down := nodeHeap{}
+	heap.Push(&down, n)
+	open := 0 // blocks in down not known to be in the past
+	for _, t := range v.tips {
+		if _, ok := past[t]; !ok {
+			past[t] = false
+			heap.Push(&down, t)
+			open++
+		}
+	}
+	var anti []*Node
+	for open > 0 {
+		c := heap.Pop(&down).(*Node)
+		inPast := past[c]
+		if !inPast {
+			open--
+			anti = append(anti, c)
+		}
+		for _, p := range c.Parents {
+			was, seen := past[p]
+			switch {
+			case !seen:
+				past[p] = inPast
+				heap.Push(&down, p)
+				if !inPast {
+					open++
+				}
+			case inPast && !was:
+				past[p] = true
+				open--
+			}
+		}
+	}
+
+	w := &AntiPastWalk{v: v}
+	for _, c := range anti {
+		for _, p := range c.Parents {
+			if past[p] {
+				heap.Push(&w.frontier, c)
+				break
+			}
+		}
+	}
+	return w
+}
+
+// Next returns up to max more blocks, or none once the walk is done.
+func (w *AntiPastWalk) Next(max int) []*Node {
+	var out []*Node
+	for len(out) < max && w.frontier.Len() > 0 {
+		c := heap.Pop(&w.frontier).(*Node)
+		for w.frontier.Len() > 0 && w.frontier[0] == c {
+			heap.Pop(&w.frontier) // pushed by more than one parent
+		}
+		w.last = c
+		out = append(out, c)
+		for _, k := range w.v.Children(c) {
+			heap.Push(&w.frontier, k)
+		}
+	}
+	return out
+}
+
+// Done reports whether Next has handed out every block.
+func (w *AntiPastWalk) Done() bool {
+	return w.frontier.Len() == 0
+}
+
+// Last returns the block Next handed out last, or nil.
+func (w *AntiPastWalk) Last() *Node {
+	return w.last
+}
+
+// chainHeap is a min-heap on height, then ID: the order of AntiPast.
+type chainHeap []*Node
+
+func (h chainHeap) Len() int { return len(h) }
+func (h chainHeap) Less(i, j int) bool {
+	if h[i].Height != h[j].Height {
+		return h[i].Height < h[j].Height
+	}
+	return h[i].Block.ID < h[j].Block.ID
+}
+func (h chainHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *chainHeap) Push(x any)   { *h = append(*h, x.(*Node)) }
+func (h *chainHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
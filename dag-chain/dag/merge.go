@@ -0,0 +1,239 @@
+package dag
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// MergeRule decides how a block's parents are merged: which parent's state
+// the block extends, and in which order the rest of its past is applied on
+// top of that state. Earlier blocks win conflicting spends.
+type MergeRule interface {
+	// SelectParent picks the parent whose UTXO state is inherited.
+	SelectParent(parents []*Node) *Node
+	// Order sorts a merge set in place into a topological order.
+	Order(mergeset []*Node)
+}
+
+// HeightOrder is the default MergeRule. It selects the heaviest parent,
+// ties to the lowest ID, and orders merge sets by height, then ID.
+type HeightOrder struct{}
+
+func (HeightOrder) SelectParent(parents []*Node) *Node {
+	var best *Node
+	for _, p := range parents {
+		if best == nil || p.Weight > best.Weight ||
+			(p.Weight == best.Weight && p.Block.ID < best.Block.ID) {
+			best = p
+		}
+	}
+	return best
+}
+
+func (HeightOrder) Order(mergeset []*Node) {
+	sort.Slice(mergeset, func(i, j int) bool {
+		a, b := mergeset[i], mergeset[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		return a.Block.ID < b.Block.ID
+	})
+}
+
+// Colorer is a MergeRule that also classifies merge sets, as GHOSTDAG
+// colors them blue and red.
+type Colorer interface {
+	MergeRule
+	// Color classifies an ordered merge set for a block extending selected.
+	Color(selected *Node, mergeset []*Node) *Coloring
+}
+
+// Coloring is the per-block result of a Colorer.
+type Coloring struct {
+	BlueScore     uint64
+	Blues         []*Node
+	Reds          []*Node
+	BluesAnticone map[*Node]int
+}
+
+func (d *DAG) mergeRule() MergeRule {
+	if d.Merge == nil {
+		return HeightOrder{}
+	}
+	return d.Merge
+}
+
+// Merged is the UTXO state a block starts from, before its own TXs.
+type Merged struct {
+	Selected *Node
+	MergeSet []*Node // in the order they were applied
+	State    *block.CommittedStore
+	Rejected []RejectedTx
+	Coloring *Coloring // nil unless the rule is a Colorer
+}
+
+// MergeParents computes the state a block with the given parents starts
+// from. It snapshots the selected parent's state and applies every block
+// in the merge set, past(block) minus past(selected parent), in the rule's
+// order. Transactions that conflict with earlier ones are skipped and
+// reported in Rejected instead of failing the merge.
+func (d *DAG) MergeParents(parents []*Node) (*Merged, error) {
+	rule := d.mergeRule()
+	m := &Merged{Selected: rule.SelectParent(parents)}
+	if m.Selected.UTXO == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoState, m.Selected.Block.ID)
+	}
+	var err error
+	if m.State, err = m.Selected.UTXO.Fork(); err != nil {
+		return nil, fmt.Errorf("snapshot parent state: %w", err)
+	}
+	m.MergeSet = mergeSet(m.Selected, parents)
+	rule.Order(m.MergeSet)
+	if c, ok := rule.(Colorer); ok {
+		m.Coloring = c.Color(m.Selected, m.MergeSet)
+	}
+	for _, n := range m.MergeSet {
+		r, err := applyMerged(m.State, n.Block)
+		if err != nil {
+			return nil, err
+		}
+		m.Rejected = append(m.Rejected, r...)
+	}
+	return m, nil
+}
+
+// Order returns past(parents) in consensus order, as if for a block with
+// those parents: the order of the selected parent's past, then the merge
+// set in the rule's order. The order is the same on every node that holds
+// the same DAG.
+func (d *DAG) Order(parents []*Node) []*Node {
+	if len(parents) == 0 {
+		return nil
+	}
+	rule := d.mergeRule()
+	selected := rule.SelectParent(parents)
+	mergeset := mergeSet(selected, parents)
+	rule.Order(mergeset)
+	return append(ChainOrder(selected), mergeset...)
+}
+
+// ChainOrder returns past(n) and n in consensus order, following the
+// selected-parent chain from genesis and each block's recorded MergeSet.
+func ChainOrder(n *Node) []*Node {
+	var chain []*Node
+	for c := n; c != nil; c = c.SelectedParent {
+		chain = append(chain, c)
+	}
+	var out []*Node
+	for i := len(chain) - 1; i >= 0; i-- {
+		out = append(out, chain[i].MergeSet...)
+		out = append(out, chain[i])
+	}
+	return out
+}
+
+// applyMerged applies the transactions of a block that was already
+// validated in its own context. Coinbase outputs are always added; any
+// other transaction that no longer applies is reported, not fatal. Only
+// storage errors are returned as err.
+func applyMerged(state block.UTXOStore, blk *block.Block) (rejected []RejectedTx, err error) {
+	for i, tx := range blk.TXs {
+		if i == 0 && tx.IsCoinbase() {
+			if _, err := block.ApplyBlock(state, blk.TXs[:1], ^uint64(0)); err != nil {
+				if !errors.Is(err, block.ErrDuplicateOutput) {
+					return nil, err
+				}
+				rejected = append(rejected, RejectedTx{BlockID: blk.ID, TxID: tx.ID, Err: err})
+			}
+			continue
+		}
+		if _, err := block.ApplyTx(state, tx); err != nil {
+			if !block.IsInvalid(err) {
+				return nil, err
+			}
+			rejected = append(rejected, RejectedTx{BlockID: blk.ID, TxID: tx.ID, Err: err})
+		}
+	}
+	return rejected, nil
+}
+
+// mergeSet returns past(parents) minus past(selected) minus selected
+// itself. Candidates are tested for membership in past(selected) by
+// walking selected's ancestors in decreasing height, only as deep as the
+// lowest candidate, so the cost follows the width of the divergence and
+// not the size of the DAG.
+func mergeSet(selected *Node, parents []*Node) []*Node {
+	past := NewPastWalker(selected)
+
+	var out []*Node
+	visited := map[*Node]bool{selected: true}
+	queue := &nodeHeap{}
+	for _, p := range parents {
+		if !visited[p] {
+			visited[p] = true
+			heap.Push(queue, p)
+		}
+	}
+	for queue.Len() > 0 {
+		n := heap.Pop(queue).(*Node)
+		if past.Contains(n) {
+			continue
+		}
+		out = append(out, n)
+		for _, p := range n.Parents {
+			if !visited[p] {
+				visited[p] = true
+				heap.Push(queue, p)
+			}
+		}
+	}
+	return out
+}
+
+// PastWalker answers whether blocks are in the past of one block by
+// expanding that past lazily, highest blocks first. Asking about blocks in
+// decreasing height walks the past once, down to the lowest block asked
+// about, however many are asked.
+type PastWalker struct {
+	seen     map[*Node]bool
+	frontier nodeHeap
+}
+
+// NewPastWalker returns a walker over the past of n.
+func NewPastWalker(n *Node) *PastWalker {
+	return &PastWalker{seen: map[*Node]bool{n: true}, frontier: nodeHeap{n}}
+}
+
+// Contains reports whether m is in the walked block's past, or is it.
+// Every path down to m only crosses blocks higher than m, so expanding
+// the frontier down to m's height is enough.
+func (w *PastWalker) Contains(m *Node) bool {
+	for w.frontier.Len() > 0 && w.frontier[0].Height >= m.Height {
+		top := heap.Pop(&w.frontier).(*Node)
+		for _, p := range top.Parents {
+			if !w.seen[p] {
+				w.seen[p] = true
+				heap.Push(&w.frontier, p)
+			}
+		}
+	}
+	return w.seen[m]
+}
+
+// nodeHeap is a max-heap on Height.
+type nodeHeap []*Node
+
+func (h nodeHeap) Len() int           { return len(h) }
+func (h nodeHeap) Less(i, j int) bool { return h[i].Height > h[j].Height }
+func (h nodeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x any)        { *h = append(*h, x.(*Node)) }
+func (h *nodeHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
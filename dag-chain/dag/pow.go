@@ -0,0 +1,94 @@
+package dag
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// NextDifficulty returns the difficulty a block on parents must meet when
+// d.Params.ProofOfWork is set.
+func (d *DAG) NextDifficulty(parents []*Node) uint64 {
+	if len(parents) == 0 {
+		return d.Params.minDifficulty()
+	}
+	return d.Params.nextDifficulty(d.mergeRule().SelectParent(parents))
+}
+
+// nextDifficulty retargets over the DifficultyWindow blocks most recently
+// ordered in selected's past: the selected chain and the blocks each chain
+// block merged. Their mean difficulty is scaled by how far the window's
+// block rate is from one per TargetSpacing, at most 4x either way.
+// Genesis is not mined and never counts; until the window fills,
+// MinDifficulty applies.
+func (p *Params) nextDifficulty(selected *Node) uint64 {
+	minimum := p.minDifficulty()
+	if p.DifficultyWindow < 2 || p.TargetSpacing <= 0 {
+		return minimum
+	}
+	var window []*Node
+	for c := selected; c != nil && c.SelectedParent != nil && len(window) < p.DifficultyWindow; c = c.SelectedParent {
+		window = append(window, c)
+		for i := len(c.MergeSet) - 1; i >= 0 && len(window) < p.DifficultyWindow; i-- {
+			window = append(window, c.MergeSet[i])
+		}
+	}
+	if len(window) < p.DifficultyWindow {
+		return minimum
+	}
+
+	sum := new(big.Int)
+	first, last := window[0].Block.Timestamp, window[0].Block.Timestamp
+	for _, n := range window {
+		sum.Add(sum, new(big.Int).SetUint64(n.Block.Difficulty))
+		if ts := n.Block.Timestamp; ts.Before(first) {
+			first = ts
+		} else if ts.After(last) {
+			last = ts
+		}
+	}
+	want := int64(p.TargetSpacing) * int64(len(window)-1)
+	actual := min(max(int64(last.Sub(first)), want/4), want*4)
+	actual = max(actual, 1)
+
+	// mean difficulty * want / actual
+	next := sum.Mul(sum, big.NewInt(want))
+	next.Div(next, big.NewInt(actual*int64(len(window))))
+	if !next.IsUint64() {
+		return ^uint64(0)
+	}
+	return max(next.Uint64(), minimum)
+}
+
+func (p *Params) minDifficulty() uint64 {
+	return max(p.MinDifficulty, 1)
+}
+
+// blueWork is the difficulty of blk and its blue past: selected's blue
+// work plus blk and the blues other than selected, which come first.
+// It saturates.
+func blueWork(blk *block.Block, selected *Node, blues []*Node) uint64 {
+	sum := selected.BlueWork
+	add := []*block.Block{blk}
+	for _, b := range blues[1:] {
+		add = append(add, b.Block)
+	}
+	for _, b := range add {
+		var carry uint64
+		if sum, carry = bits.Add64(sum, b.Difficulty, 0); carry != 0 {
+			return math.MaxUint64
+		}
+	}
+	return sum
+}
+
+// work is what blk adds to its weight: its difficulty under proof of
+// work, otherwise its transaction count.
+func (p *Params) work(blk *block.Block) uint64 {
+	if p.ProofOfWork {
+		return blk.Difficulty
+	}
+	return uint64(len(blk.TXs))
+}
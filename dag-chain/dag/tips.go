@@ -0,0 +1,139 @@
+package dag
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// Heavier reports whether a ranks above b as a tip: by blue work, then
+// blue score, then weight, then lowest ID. Blue work is only counted
+// under ProofOfWork and blue scores only under a Colorer; without them
+// weight decides. Ranking by work rather than by block count keeps a
+// branch that lowered its difficulty to make more blocks from winning
+// with less work.
+func Heavier(a, b *Node) bool {
+	if a.BlueWork != b.BlueWork {
+		return a.BlueWork > b.BlueWork
+	}
+	if a.BlueScore != b.BlueScore {
+		return a.BlueScore > b.BlueScore
+	}
+	if a.Weight != b.Weight {
+		return a.Weight > b.Weight
+	}
+	return a.Block.ID < b.Block.ID
+}
+
+// Tips returns the blocks with no children, heaviest first. It costs
+// O(t log t) in the number of tips t, not in the size of the DAG.
+func (d *DAG) Tips() []*Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.tips.sorted()
+}
+
+// HeaviestTip returns the tip ranked first by Heavier, or nil for an
+// empty DAG.
+func (d *DAG) HeaviestTip() *Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.tips.heaviest()
+}
+
+// RemoveTip deletes a block with no children from the DAG. Parents left
+// without children become tips again, and subscribers hear if the
+// heaviest tip moves. Removing a whole branch therefore
+// goes from its highest blocks down.
+func (d *DAG) RemoveTip(id string) (*Node, error) {
+	d.write.Lock()
+	defer d.write.Unlock()
+	d.mu.Lock()
+	it, ok := d.index.lookup(id)
+	if !ok {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("block %s not found", id)
+	}
+	n := it.node
+	if len(it.children) != 0 {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("block %s has %d children", id, len(it.children))
+	}
+	prevTip := d.tips.heaviest()
+	d.tips.remove(n)
+	for _, p := range n.Parents {
+		var children []*Node
+		for _, c := range d.index.children(p) {
+			if c != n {
+				children = append(children, c)
+			}
+		}
+		d.index.put(indexItem{node: p, children: children})
+		if len(children) == 0 {
+			d.tips.add(p)
+		}
+	}
+	d.index.remove(id)
+	if d.genesis == n {
+		d.genesis = nil
+	}
+	tip := d.tips.heaviest()
+	d.mu.Unlock()
+	d.publishTip(prevTip, tip)
+	return n, nil
+}
+
+// tipIndex is a max-heap of the current tips under Heavier. It tracks each
+// tip's position so a tip gaining a child is removed in O(log t).
+type tipIndex struct {
+	nodes []*Node
+	pos   map[*Node]int
+}
+
+func (t *tipIndex) heaviest() *Node {
+	if len(t.nodes) == 0 {
+		return nil
+	}
+	return t.nodes[0]
+}
+
+// sorted returns the tips heaviest first.
+func (t *tipIndex) sorted() []*Node {
+	tips := append([]*Node(nil), t.nodes...)
+	sort.Slice(tips, func(i, j int) bool { return Heavier(tips[i], tips[j]) })
+	return tips
+}
+
+func (t *tipIndex) add(n *Node) {
+	if t.pos == nil {
+		t.pos = make(map[*Node]int)
+	}
+	if _, ok := t.pos[n]; !ok {
+		heap.Push(t, n)
+	}
+}
+
+func (t *tipIndex) remove(n *Node) {
+	if i, ok := t.pos[n]; ok {
+		heap.Remove(t, i)
+	}
+}
+
+func (t *tipIndex) Len() int           { return len(t.nodes) }
+func (t *tipIndex) Less(i, j int) bool { return Heavier(t.nodes[i], t.nodes[j]) }
+func (t *tipIndex) Swap(i, j int) {
+	t.nodes[i], t.nodes[j] = t.nodes[j], t.nodes[i]
+	t.pos[t.nodes[i]] = i
+	t.pos[t.nodes[j]] = j
+}
+func (t *tipIndex) Push(x any) {
+	n := x.(*Node)
+	t.pos[n] = len(t.nodes)
+	t.nodes = append(t.nodes, n)
+}
+func (t *tipIndex) Pop() any {
+	n := t.nodes[len(t.nodes)-1]
+	t.nodes = t.nodes[:len(t.nodes)-1]
+	delete(t.pos, n)
+	return n
+}
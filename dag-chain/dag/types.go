@@ -1,17 +1,54 @@
 package dag
 
-import "github.com/Abdullah-zahoor/dagchain/block"
+import (
+	"sync"
 
-// Node wraps a block and links to parents/children.
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// Node wraps a block and links to its parents. Once a node is in the DAG
+// it does not change; its children are kept by the DAG, so read them
+// through DAG.Children or a View.
 type Node struct {
-	Block    *block.Block
-	Parents  []*Node
-	Children []*Node
-	Weight   uint64 // cumulative work or tx count
-	UTXO     block.UTXOSet
+	Block   *block.Block
+	Parents []*Node
+	Weight  uint64                // cumulative work or tx count
+	Height  uint64                // longest path from genesis
+	UTXO    *block.CommittedStore // state after the block's TXs; nil if not restored
+
+	SelectedParent *Node        // parent whose state UTXO extends
+	MergeSet       []*Node      // other blocks merged into UTXO, in order
+	Rejected       []RejectedTx // merge-set TXs that lost a conflict
+
+	// Filled in by a Colorer merge rule such as GHOSTDAG.
+	BlueScore     uint64        // blue blocks in past, this one excluded
+	BlueWork      uint64        // under ProofOfWork, difficulty of this block and its blue past
+	Blues         []*Node       // merge-set blues, selected parent first
+	Reds          []*Node       // merge-set reds
+	BluesAnticone map[*Node]int // blue anticone size of each of Blues
+}
+
+// RejectedTx is a transaction from a merged block that was not applied
+// because an earlier block in the merge order spent the same output.
+type RejectedTx struct {
+	BlockID string
+	TxID    string
+	Err     error
 }
 
-// DAG holds all nodes by their Block.ID.
+// DAG holds all nodes by their Block.ID. It is safe for concurrent use:
+// writes are serialized, and readers never wait for a block's validation,
+// only for it to be linked in. Set Merge and Params before sharing it.
 type DAG struct {
-	Nodes map[string]*Node
+	Merge  MergeRule // nil means HeightOrder
+	Params Params    // limits AddBlock enforces; zero disables them
+
+	write sync.Mutex // held by AddGenesis, AddBlock and RemoveTip throughout
+
+	mu      sync.RWMutex // guards the fields below
+	index   nodeIndex    // nodes and their children by ID
+	genesis *Node
+	tips    tipIndex // nodes with no children, kept by AddBlock and RemoveTip
+
+	bus bus
 }
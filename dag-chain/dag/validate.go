@@ -0,0 +1,222 @@
+package dag
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// Structural failures reported by AddBlock. Each comes wrapped in a
+// *RuleError; classify it with errors.Is.
+var (
+	ErrNoParents        = errors.New("non-genesis block without parents")
+	ErrDuplicateParent  = errors.New("parent listed twice")
+	ErrTooManyParents   = errors.New("too many parents")
+	ErrRedundantParent  = errors.New("parent is an ancestor of another parent")
+	ErrTimeTooFar       = errors.New("timestamp too far in the future")
+	ErrTimeTooOld       = errors.New("timestamp not after median time past")
+	ErrBlockTooLarge    = errors.New("block too large")
+	ErrMissingCoinbase  = errors.New("block does not start with a coinbase")
+	ErrEmptyCoinbase    = errors.New("coinbase has no outputs")
+	ErrBadDifficulty    = errors.New("difficulty does not match the DAG's")
+	ErrInsufficientWork = errors.New("block hash does not meet its difficulty")
+	ErrUTXORootMismatch = errors.New("utxo root does not match the resulting set")
+)
+
+// ruleErrors lists every sentinel above, for IsInvalid.
+var ruleErrors = []error{
+	ErrNoParents, ErrDuplicateParent, ErrTooManyParents, ErrRedundantParent,
+	ErrTimeTooFar, ErrTimeTooOld, ErrBlockTooLarge, ErrMissingCoinbase, ErrEmptyCoinbase,
+	ErrBadDifficulty, ErrInsufficientWork, ErrUTXORootMismatch,
+}
+
+// RuleError reports a block breaking a consensus rule.
+type RuleError struct {
+	BlockID string
+	Err     error  // one of the rule sentinels above
+	Detail  string // the offending values
+}
+
+func (e *RuleError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("block %s: %v", e.BlockID, e.Err)
+	}
+	return fmt.Sprintf("block %s: %v: %s", e.BlockID, e.Err, e.Detail)
+}
+
+func (e *RuleError) Unwrap() error { return e.Err }
+
+// IsInvalid reports whether an AddBlock error means the block itself is
+// invalid, so whoever sent it can be penalized. Missing parents,
+// duplicates and storage errors are not.
+func IsInvalid(err error) bool {
+	for _, target := range ruleErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return block.IsInvalid(err)
+}
+
+// Params are the consensus limits AddBlock checks on top of the rules it
+// always enforces: at least one parent and no parent listed twice. A zero
+// field disables its check.
+type Params struct {
+	MaxParents         int
+	MaxBlockSize       int           // bytes of Block.Encode
+	MaxFutureDrift     time.Duration // how far ahead of Now a timestamp may be
+	MedianTimeSpan     int           // selected-chain blocks in median time past
+	NoRedundantParents bool
+	RequireCoinbase    bool             // first tx must be a coinbase with outputs
+	RequireUTXORoot    bool             // header must commit to the UTXO set; a present root is always checked
+	Now                func() time.Time // nil means time.Now
+
+	// With ProofOfWork, every block must meet the difficulty NextDifficulty
+	// gives for its parents, and weight is cumulative difficulty instead of
+	// transaction count. Under a Colorer, tips and selected parents then
+	// rank by blue work; see Heavier.
+	ProofOfWork      bool
+	MinDifficulty    uint64        // floor, and the difficulty until the window fills
+	TargetSpacing    time.Duration // desired time between blocks across the DAG
+	DifficultyWindow int           // blocks the adjustment averages over
+}
+
+// DefaultParams returns the limits used by the node and simulator.
+func DefaultParams() Params {
+	return Params{
+		MaxParents:         10,
+		MaxBlockSize:       1 << 20,
+		MaxFutureDrift:     2 * time.Minute,
+		MedianTimeSpan:     11,
+		NoRedundantParents: true,
+		RequireCoinbase:    true,
+		RequireUTXORoot:    true,
+		MinDifficulty:      1,
+		TargetSpacing:      time.Second,
+		DifficultyWindow:   64,
+	}
+}
+
+// checkContent applies the rules that need only the block itself.
+func (p *Params) checkContent(blk *block.Block) error {
+	fail := func(err error, format string, args ...any) error {
+		return &RuleError{BlockID: blk.ID, Err: err, Detail: fmt.Sprintf(format, args...)}
+	}
+	if len(blk.Parents) == 0 {
+		return fail(ErrNoParents, "")
+	}
+	seen := make(map[string]bool, len(blk.Parents))
+	for _, pid := range blk.Parents {
+		if seen[pid] {
+			return fail(ErrDuplicateParent, "%s", pid)
+		}
+		seen[pid] = true
+	}
+	if p.MaxParents > 0 && len(blk.Parents) > p.MaxParents {
+		return fail(ErrTooManyParents, "%d > %d", len(blk.Parents), p.MaxParents)
+	}
+	if p.MaxBlockSize > 0 {
+		if size := len(blk.Encode()); size > p.MaxBlockSize {
+			return fail(ErrBlockTooLarge, "%d > %d bytes", size, p.MaxBlockSize)
+		}
+	}
+	if p.RequireCoinbase {
+		if len(blk.TXs) == 0 || !blk.TXs[0].IsCoinbase() {
+			return fail(ErrMissingCoinbase, "")
+		}
+		if len(blk.TXs[0].Outputs) == 0 {
+			return fail(ErrEmptyCoinbase, "")
+		}
+	}
+	if p.ProofOfWork && !blk.MeetsTarget() {
+		return fail(ErrInsufficientWork, "difficulty %d", blk.Difficulty)
+	}
+	if p.MaxFutureDrift > 0 {
+		now := time.Now
+		if p.Now != nil {
+			now = p.Now
+		}
+		if limit := now().Add(p.MaxFutureDrift); blk.Timestamp.After(limit) {
+			return fail(ErrTimeTooFar, "%s after %s", blk.Timestamp.Format(time.RFC3339Nano), limit.Format(time.RFC3339Nano))
+		}
+	}
+	return nil
+}
+
+// checkParents applies the rules that need the block's place in the DAG.
+func (p *Params) checkParents(blk *block.Block, parents []*Node, selected *Node) error {
+	if p.ProofOfWork {
+		if want := p.nextDifficulty(selected); blk.Difficulty != want {
+			detail := fmt.Sprintf("%d, want %d", blk.Difficulty, want)
+			return &RuleError{BlockID: blk.ID, Err: ErrBadDifficulty, Detail: detail}
+		}
+	}
+	if p.NoRedundantParents {
+		for _, a := range parents {
+			for _, b := range parents {
+				if a != b && IsAncestor(a, b) {
+					detail := fmt.Sprintf("%s is in the past of %s", a.Block.ID, b.Block.ID)
+					return &RuleError{BlockID: blk.ID, Err: ErrRedundantParent, Detail: detail}
+				}
+			}
+		}
+	}
+	if p.MedianTimeSpan > 0 {
+		if mtp := MedianTimePast(selected, p.MedianTimeSpan); !blk.Timestamp.After(mtp) {
+			detail := fmt.Sprintf("%s not after %s", blk.Timestamp.Format(time.RFC3339Nano), mtp.Format(time.RFC3339Nano))
+			return &RuleError{BlockID: blk.ID, Err: ErrTimeTooOld, Detail: detail}
+		}
+	}
+	return nil
+}
+
+// checkUTXORoot compares the header's UTXORoot with state, the block's
+// merged parent state with its own transactions applied.
+func (p *Params) checkUTXORoot(blk *block.Block, state *block.CommittedStore) error {
+	if blk.UTXORoot == "" && !p.RequireUTXORoot {
+		return nil
+	}
+	if root := state.Root(); blk.UTXORoot != root {
+		detail := fmt.Sprintf("header has %q, set gives %s", blk.UTXORoot, root)
+		return &RuleError{BlockID: blk.ID, Err: ErrUTXORootMismatch, Detail: detail}
+	}
+	return nil
+}
+
+// MedianTimePast returns the median timestamp of n and the span-1 blocks
+// below it on its selected chain, or fewer near genesis.
+func MedianTimePast(n *Node, span int) time.Time {
+	var times []time.Time
+	for c := n; c != nil && len(times) < span; c = c.SelectedParent {
+		times = append(times, c.Block.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2]
+}
+
+// IsAncestor reports whether a is in the past of b, or is b. Heights
+// strictly increase from parent to child, so the walk stops at a's height.
+func IsAncestor(a, b *Node) bool {
+	if a == b {
+		return true
+	}
+	seen := map[*Node]bool{b: true}
+	stack := []*Node{b}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range n.Parents {
+			if p == a {
+				return true
+			}
+			if !seen[p] && p.Height > a.Height {
+				seen[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package dag_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+func TestStructuralRules(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	d := dag.NewDAG()
+	d.Params = dag.DefaultParams()
+	d.Params.MaxParents = 2
+	d.Params.MaxBlockSize = 4096
+	d.Params.MedianTimeSpan = 3
+	d.Params.RequireUTXORoot = false // covered by TestUTXORoot
+	d.Params.Now = func() time.Time { return ts.Add(time.Hour) }
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := 0
+	coinbase := func() block.TX {
+		nonce++
+		return block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte{byte(nonce)})
+	}
+	// mk builds a block with a coinbase at ts plus sec seconds
+	mk := func(sec int, parents ...string) *block.Block {
+		return block.NewBlock(parents, []block.TX{coinbase()}, ts.Add(time.Duration(sec)*time.Second))
+	}
+	mustAdd := func(blk *block.Block) *block.Block {
+		t.Helper()
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		return blk
+	}
+	a := mustAdd(mk(10, gen.ID))
+	b := mustAdd(mk(20, gen.ID))
+	c := mustAdd(mk(30, gen.ID))
+	a2 := mustAdd(mk(40, a.ID))
+
+	padded := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, make([]byte, 5000))
+	big := block.NewBlock([]string{a2.ID}, []block.TX{padded}, ts.Add(50*time.Second))
+
+	for _, tc := range []struct {
+		name string
+		blk  *block.Block
+		want error
+	}{
+		{"no parents", mk(50), dag.ErrNoParents},
+		{"duplicate parent", mk(50, a2.ID, a2.ID), dag.ErrDuplicateParent},
+		{"too many parents", mk(50, a2.ID, b.ID, c.ID), dag.ErrTooManyParents},
+		{"redundant parent", mk(50, a2.ID, a.ID), dag.ErrRedundantParent},
+		{"too far ahead", mk(7200, a2.ID), dag.ErrTimeTooFar},
+		{"before median time past", mk(5, a2.ID), dag.ErrTimeTooOld},
+		{"too large", big, dag.ErrBlockTooLarge},
+		{"no coinbase", block.NewBlock([]string{a2.ID}, nil, ts.Add(50*time.Second)), dag.ErrMissingCoinbase},
+		{"empty coinbase", block.NewBlock([]string{a2.ID}, []block.TX{block.NewTX(nil, nil, []byte("x"))}, ts.Add(50*time.Second)), dag.ErrEmptyCoinbase},
+	} {
+		err := d.AddBlock(tc.blk)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+			continue
+		}
+		var re *dag.RuleError
+		if !errors.As(err, &re) || re.BlockID != tc.blk.ID || !dag.IsInvalid(err) {
+			t.Errorf("%s: %v is not a RuleError for the block", tc.name, err)
+		}
+	}
+	if d.Node(a2.ID) == nil || d.Len() != 5 {
+		t.Errorf("DAG has %d blocks after rejections, want 5", d.Len())
+	}
+	if err := d.AddBlock(mk(50, a2.ID, b.ID)); err != nil {
+		t.Errorf("valid merge block rejected: %v", err)
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	d := dag.NewDAG()
+	d.Params = dag.Params{ProofOfWork: true, MinDifficulty: 8, TargetSpacing: time.Second, DifficultyWindow: 4}
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks four times faster than the target spacing
+	tip := d.Genesis()
+	for i := 1; i <= 4; i++ {
+		blk := block.NewBlock([]string{tip.Block.ID}, nil, ts.Add(time.Duration(i)*250*time.Millisecond))
+		blk.Difficulty = d.NextDifficulty([]*dag.Node{tip})
+		if blk.Difficulty != 8 {
+			t.Fatalf("block %d: difficulty %d before the window fills, want 8", i, blk.Difficulty)
+		}
+		if !blk.Mine(1 << 20) {
+			t.Fatal("no nonce found")
+		}
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		tip = d.Node(blk.ID)
+		if tip.Weight != uint64(8*i) {
+			t.Fatalf("block %d: weight %d, want cumulative difficulty %d", i, tip.Weight, 8*i)
+		}
+	}
+	// 4 blocks over 750ms against 3s wanted: difficulty rises 4x
+	next := d.NextDifficulty([]*dag.Node{tip})
+	if next != 32 {
+		t.Fatalf("retargeted difficulty %d, want 32", next)
+	}
+
+	blk := block.NewBlock([]string{tip.Block.ID}, nil, ts.Add(2*time.Second))
+	blk.Difficulty = 8
+	blk.Mine(1 << 20)
+	if err := d.AddBlock(blk); !errors.Is(err, dag.ErrBadDifficulty) {
+		t.Errorf("stale difficulty: got %v", err)
+	}
+	blk.Difficulty = next
+	for blk.Nonce = 0; ; blk.Nonce++ {
+		if blk.ID = blk.Hash(); !blk.MeetsTarget() {
+			break
+		}
+	}
+	if err := d.AddBlock(blk); !errors.Is(err, dag.ErrInsufficientWork) {
+		t.Errorf("unmined block: got %v", err)
+	}
+}
+
+func TestUTXORoot(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	d := dag.NewDAG()
+	d.Params.RequireUTXORoot = true
+	gen := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(gen, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	mint := block.NewTX(nil, []block.TXOutput{{Value: 50, Recipient: "m"}}, nil)
+	mk := func(root string) *block.Block {
+		blk := block.NewBlock([]string{gen.ID}, []block.TX{mint}, ts.Add(time.Second))
+		blk.UTXORoot = root
+		blk.ID = blk.Hash()
+		return blk
+	}
+	for _, root := range []string{"", d.Genesis().UTXO.Root()} {
+		err := d.AddBlock(mk(root))
+		if !errors.Is(err, dag.ErrUTXORootMismatch) || !dag.IsInvalid(err) {
+			t.Errorf("root %q: got %v, want ErrUTXORootMismatch", root, err)
+		}
+	}
+
+	want, err := d.MergeParents([]*dag.Node{d.Genesis()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := want.State.Put(block.UTXOKey{TxID: mint.ID}, mint.Outputs[0]); err != nil {
+		t.Fatal(err)
+	}
+	blk := mk(want.State.Root())
+	if err := d.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	node := d.Node(blk.ID)
+	if node.UTXO.Root() != blk.UTXORoot {
+		t.Fatalf("node root %s, header root %s", node.UTXO.Root(), blk.UTXORoot)
+	}
+	out, ok, err := block.VerifyUTXOProof(node.UTXO.Prove(block.UTXOKey{TxID: mint.ID}), blk.UTXORoot)
+	if err != nil || !ok || out.Value != 50 {
+		t.Errorf("membership proof: %v %v %v", out, ok, err)
+	}
+	if _, ok, err := block.VerifyUTXOProof(d.Genesis().UTXO.Prove(block.UTXOKey{TxID: mint.ID}), d.Genesis().UTXO.Root()); err != nil || ok {
+		t.Errorf("genesis non-membership proof: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,69 @@
+package dag
+
+import (
+	"iter"
+	"slices"
+	"sort"
+)
+
+// View is a read-only snapshot of a DAG: the blocks, tips and child links
+// as of one moment, unaffected by later writes. The DAG's index is
+// persistent, so taking a View copies only a pointer and the tips and
+// holds up writers for no longer than that. Walks over the whole DAG,
+// such as rendering it or picking a finality point, see one consistent
+// state while blocks keep arriving.
+type View struct {
+	Genesis *Node
+
+	index nodeIndex
+	tips  []*Node // heaviest first
+}
+
+// View returns a snapshot of d.
+func (d *DAG) View() *View {
+	d.mu.RLock()
+	v := &View{
+		Genesis: d.genesis,
+		index:   d.index.snapshot(),
+		tips:    slices.Clone(d.tips.nodes),
+	}
+	d.mu.RUnlock()
+	sort.Slice(v.tips, func(i, j int) bool { return Heavier(v.tips[i], v.tips[j]) })
+	return v
+}
+
+// Node returns the node for a block ID, or nil if it is not in the view.
+func (v *View) Node(id string) *Node {
+	return v.index.node(id)
+}
+
+// Len returns the number of blocks in the view.
+func (v *View) Len() int {
+	return v.index.len()
+}
+
+// Nodes iterates over the view's blocks by ID in no particular order.
+func (v *View) Nodes() iter.Seq2[string, *Node] {
+	return func(yield func(string, *Node) bool) {
+		v.index.each(func(n *Node) bool { return yield(n.Block.ID, n) })
+	}
+}
+
+// Tips returns the view's tips, heaviest first.
+func (v *View) Tips() []*Node {
+	return append([]*Node(nil), v.tips...)
+}
+
+// HeaviestTip returns the view's heaviest tip, or nil if it is empty.
+func (v *View) HeaviestTip() *Node {
+	if len(v.tips) == 0 {
+		return nil
+	}
+	return v.tips[0]
+}
+
+// Children returns n's children in the view. The slice must not be
+// modified.
+func (v *View) Children(n *Node) []*Node {
+	return v.index.children(n)
+}
@@ -0,0 +1,346 @@
+// Package hamt is a persistent hash array mapped trie, shared by the UTXO
+// sets in block and the DAG's node index.
+package hamt
+
+import (
+	"math/bits"
+	"slices"
+	"sync/atomic"
+)
+
+// Each level consumes levelBits of the key hash.
+const (
+	levelBits  = 5
+	levelWidth = 1 << levelBits
+	levelMask  = levelWidth - 1
+)
+
+// Map is a hash array mapped trie whose Snapshot is O(1) and shares every
+// node with the original. A write copies only the path to the changed
+// leaf.
+//
+// Nodes are copy-on-write: a map mutates in place only the nodes it
+// allocated since its last Snapshot. Reads and Snapshot may run
+// concurrently with each other, but not with a write.
+type Map[K comparable, V any] struct {
+	hash   func(K) uint64
+	root   *node[K, V]
+	owner  *owner
+	frozen atomic.Bool // set by Snapshot: the next write takes a new owner
+}
+
+// owner tags the nodes a map may mutate in place. It has non-zero size so
+// that every allocation is a distinct pointer.
+type owner struct{ _ byte }
+
+type node[K comparable, V any] struct {
+	bitmap  uint32
+	entries []entry[K, V]
+	size    int // items in this subtree
+	owner   *owner
+}
+
+// entry is either a subtree (child != nil) or a leaf holding every item
+// whose key hashes to hash. Leaf item slices are never mutated in place.
+type entry[K comparable, V any] struct {
+	child *node[K, V]
+	hash  uint64
+	items []item[K, V]
+}
+
+type item[K comparable, V any] struct {
+	key K
+	val V
+}
+
+// New returns an empty map keyed through hash.
+func New[K comparable, V any](hash func(K) uint64) *Map[K, V] {
+	return &Map[K, V]{hash: hash, owner: new(owner)}
+}
+
+// Len returns the number of entries.
+func (m *Map[K, V]) Len() int {
+	if m.root == nil {
+		return 0
+	}
+	return m.root.size
+}
+
+func (n *node[K, V]) slot(h uint64, shift uint) (bit uint32, pos int) {
+	bit = 1 << ((h >> shift) & levelMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// Get returns the value for key.
+func (m *Map[K, V]) Get(key K) (V, bool) {
+	return m.root.get(m.hash(key), 0, key)
+}
+
+func (n *node[K, V]) get(h uint64, shift uint, key K) (V, bool) {
+	for n != nil {
+		bit, pos := n.slot(h, shift)
+		if n.bitmap&bit == 0 {
+			break
+		}
+		e := &n.entries[pos]
+		if e.child != nil {
+			n, shift = e.child, shift+levelBits
+			continue
+		}
+		if e.hash == h {
+			for _, it := range e.items {
+				if it.key == key {
+					return it.val, true
+				}
+			}
+		}
+		break
+	}
+	var zero V
+	return zero, false
+}
+
+// editable returns n itself if m owns it, otherwise a copy that m owns.
+func (m *Map[K, V]) editable(n *node[K, V]) *node[K, V] {
+	if n == nil {
+		return &node[K, V]{owner: m.owner}
+	}
+	if n.owner == m.owner {
+		return n
+	}
+	return &node[K, V]{
+		bitmap:  n.bitmap,
+		entries: slices.Clone(n.entries),
+		size:    n.size,
+		owner:   m.owner,
+	}
+}
+
+func (m *Map[K, V]) put(n *node[K, V], h uint64, shift uint, it item[K, V]) (*node[K, V], bool) {
+	n = m.editable(n)
+	bit, pos := n.slot(h, shift)
+	if n.bitmap&bit == 0 {
+		n.entries = slices.Insert(n.entries, pos, entry[K, V]{hash: h, items: []item[K, V]{it}})
+		n.bitmap |= bit
+		n.size++
+		return n, true
+	}
+
+	e := &n.entries[pos]
+	var added bool
+	switch {
+	case e.child != nil:
+		e.child, added = m.put(e.child, h, shift+levelBits, it)
+	case e.hash == h:
+		i := slices.IndexFunc(e.items, func(x item[K, V]) bool { return x.key == it.key })
+		items := slices.Clone(e.items)
+		if i >= 0 {
+			items[i] = it
+		} else {
+			items, added = append(items, it), true
+		}
+		e.items = items
+	default:
+		// Two hashes share this slot: push the leaf one level down.
+		child := &node[K, V]{owner: m.owner, size: len(e.items)}
+		cbit, _ := child.slot(e.hash, shift+levelBits)
+		child.bitmap = cbit
+		child.entries = []entry[K, V]{{hash: e.hash, items: e.items}}
+		child, added = m.put(child, h, shift+levelBits, it)
+		*e = entry[K, V]{child: child}
+	}
+	if added {
+		n.size++
+	}
+	return n, added
+}
+
+func (m *Map[K, V]) del(n *node[K, V], h uint64, shift uint, key K) (*node[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+	bit, pos := n.slot(h, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	e := n.entries[pos]
+	if e.child != nil {
+		child, removed := m.del(e.child, h, shift+levelBits, key)
+		if !removed {
+			return n, false
+		}
+		n = m.editable(n)
+		switch {
+		case child == nil:
+			n.removeEntry(bit, pos)
+		case len(child.entries) == 1 && child.entries[0].child == nil:
+			// collapse a subtree that is down to one leaf
+			n.entries[pos] = child.entries[0]
+		default:
+			n.entries[pos].child = child
+		}
+	} else {
+		if e.hash != h {
+			return n, false
+		}
+		i := slices.IndexFunc(e.items, func(x item[K, V]) bool { return x.key == key })
+		if i < 0 {
+			return n, false
+		}
+		n = m.editable(n)
+		if len(e.items) == 1 {
+			n.removeEntry(bit, pos)
+		} else {
+			n.entries[pos].items = slices.Delete(slices.Clone(e.items), i, i+1)
+		}
+	}
+	n.size--
+	if n.size == 0 {
+		return nil, true
+	}
+	return n, true
+}
+
+func (n *node[K, V]) removeEntry(bit uint32, pos int) {
+	n.entries = slices.Delete(n.entries, pos, pos+1)
+	n.bitmap &^= bit
+}
+
+func (n *node[K, V]) each(fn func(K, V) bool) bool {
+	for _, e := range n.entries {
+		if e.child != nil {
+			if !e.child.each(fn) {
+				return false
+			}
+			continue
+		}
+		for _, it := range e.items {
+			if !fn(it.key, it.val) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// thaw gives m a new owner after a Snapshot, so that nodes shared with
+// the snapshot are copied before they change.
+func (m *Map[K, V]) thaw() {
+	if m.frozen.Load() {
+		m.owner = new(owner)
+		m.frozen.Store(false)
+	}
+}
+
+// Put sets the value for key.
+func (m *Map[K, V]) Put(key K, val V) {
+	m.thaw()
+	m.root, _ = m.put(m.root, m.hash(key), 0, item[K, V]{key: key, val: val})
+}
+
+// Delete removes key, if present.
+func (m *Map[K, V]) Delete(key K) {
+	m.thaw()
+	m.root, _ = m.del(m.root, m.hash(key), 0, key)
+}
+
+// Each visits entries in hash order, which is the same for equal sets,
+// until fn returns false.
+func (m *Map[K, V]) Each(fn func(K, V) bool) {
+	if m.root != nil {
+		m.root.each(fn)
+	}
+}
+
+// Snapshot is O(1): both maps keep the current nodes and copy them on
+// their next write.
+func (m *Map[K, V]) Snapshot() *Map[K, V] {
+	m.frozen.Store(true)
+	return &Map[K, V]{hash: m.hash, root: m.root, owner: new(owner)}
+}
+
+// Intersect drops every entry of m whose key other does not hold. Subtrees
+// the two maps share are skipped, so the cost is proportional to how far
+// they have diverged rather than to their size. Keys are compared, not
+// values: where the maps hold a key with different values, either may be
+// kept. Afterwards m may share nodes with other, so both copy them on
+// their next write.
+func (m *Map[K, V]) Intersect(other *Map[K, V]) {
+	m.thaw()
+	other.frozen.Store(true)
+	m.root = m.intersect(m.root, other.root, 0)
+}
+
+func (m *Map[K, V]) intersect(a, b *node[K, V], shift uint) *node[K, V] {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a == b {
+		return a
+	}
+	out := &node[K, V]{owner: m.owner}
+	for both := a.bitmap & b.bitmap; both != 0; both &= both - 1 {
+		bit := both & -both
+		ea := a.entries[bits.OnesCount32(a.bitmap&(bit-1))]
+		eb := b.entries[bits.OnesCount32(b.bitmap&(bit-1))]
+
+		var e entry[K, V]
+		switch {
+		case ea.child != nil && eb.child != nil:
+			e.child = m.intersect(ea.child, eb.child, shift+levelBits)
+			if e.child == nil {
+				continue
+			}
+			out.size += e.child.size
+		case ea.child == nil:
+			e.hash = ea.hash
+			e.items = keepItems(ea.items, func(it item[K, V]) bool {
+				if eb.child != nil {
+					_, ok := eb.child.get(ea.hash, shift+levelBits, it.key)
+					return ok
+				}
+				return eb.hash == ea.hash && slices.ContainsFunc(eb.items,
+					func(x item[K, V]) bool { return x.key == it.key })
+			})
+			out.size += len(e.items)
+		default:
+			e.hash = eb.hash
+			e.items = keepItems(eb.items, func(it item[K, V]) bool {
+				_, ok := ea.child.get(eb.hash, shift+levelBits, it.key)
+				return ok
+			})
+			out.size += len(e.items)
+		}
+		if e.child == nil && len(e.items) == 0 {
+			continue
+		}
+		out.entries = append(out.entries, e)
+		out.bitmap |= bit
+	}
+	// The result is a subset of both inputs, so equal size means equal
+	// content. Reusing an input keeps the maps converging on shared nodes.
+	switch {
+	case out.size == 0:
+		return nil
+	case out.size == b.size:
+		return b
+	case out.size == a.size:
+		return a
+	}
+	return out
+}
+
+// keepItems returns the items for which keep is true, reusing items when
+// all of them are kept.
+func keepItems[K comparable, V any](items []item[K, V], keep func(item[K, V]) bool) []item[K, V] {
+	kept := make([]item[K, V], 0, len(items))
+	for _, it := range items {
+		if keep(it) {
+			kept = append(kept, it)
+		}
+	}
+	if len(kept) == len(items) {
+		return items
+	}
+	return kept
+}
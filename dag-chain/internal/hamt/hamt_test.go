@@ -0,0 +1,112 @@
+package hamt_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/Abdullah-zahoor/dagchain/internal/hamt"
+)
+
+// TestMatchesMap checks a map whose hash keeps only a few bits, so keys
+// collide at every depth, against a Go map, with snapshots along the way.
+func TestMatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := hamt.New[int, int](func(k int) uint64 { return uint64(k%97) << 59 })
+	model := make(map[int]int)
+	type snap struct {
+		m     *hamt.Map[int, int]
+		model map[int]int
+	}
+	var snaps []snap
+	for i := 0; i < 20000; i++ {
+		k := rng.Intn(2000)
+		switch rng.Intn(10) {
+		case 0, 1, 2:
+			m.Delete(k)
+			delete(model, k)
+		case 3:
+			if i%500 == 0 {
+				c := make(map[int]int, len(model))
+				for k, v := range model {
+					c[k] = v
+				}
+				snaps = append(snaps, snap{m.Snapshot(), c})
+			}
+		default:
+			m.Put(k, i)
+			model[k] = i
+		}
+	}
+	check(t, "map", m, model)
+	for _, s := range snaps {
+		check(t, "snapshot", s.m, s.model)
+	}
+}
+
+func check(t *testing.T, what string, m *hamt.Map[int, int], model map[int]int) {
+	t.Helper()
+	if m.Len() != len(model) {
+		t.Fatalf("%s: Len = %d, want %d", what, m.Len(), len(model))
+	}
+	seen := 0
+	m.Each(func(k, v int) bool {
+		if want, ok := model[k]; !ok || v != want {
+			t.Fatalf("%s: %d = %d, want %d", what, k, v, want)
+		}
+		seen++
+		return true
+	})
+	if seen != len(model) {
+		t.Fatalf("%s: Each visited %d entries, want %d", what, seen, len(model))
+	}
+	for k, want := range model {
+		if v, ok := m.Get(k); !ok || v != want {
+			t.Fatalf("%s: Get(%d) = %d, %v", what, k, v, ok)
+		}
+	}
+}
+
+// TestIntersect intersects two maps that diverged from a common snapshot,
+// then writes to both. A key has the same value wherever it is held.
+func TestIntersect(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	base := hamt.New[int, int](func(k int) uint64 { return uint64(k%97) << 59 })
+	for k := 0; k < 3000; k++ {
+		base.Put(k, -k)
+	}
+	a, b := base.Snapshot(), base.Snapshot()
+	am, bm := make(map[int]int), make(map[int]int)
+	for k := 0; k < 3000; k++ {
+		am[k], bm[k] = -k, -k
+	}
+	for i := 0; i < 400; i++ {
+		m, model := a, am
+		if i%2 == 1 {
+			m, model = b, bm
+		}
+		if k := rng.Intn(3000); rng.Intn(3) == 0 {
+			m.Put(k+3000, -k-3000)
+			model[k+3000] = -k - 3000
+		} else {
+			m.Delete(k)
+			delete(model, k)
+		}
+	}
+	want := make(map[int]int)
+	for k, v := range am {
+		if _, ok := bm[k]; ok {
+			want[k] = v
+		}
+	}
+	a.Intersect(b)
+	check(t, "intersection", a, want)
+	a.Put(-1, 1)
+	want[-1] = 1
+	b.Delete(0)
+	delete(bm, 0)
+	check(t, "intersection", a, want)
+	check(t, "other", b, bm)
+	if base.Len() != 3000 {
+		t.Error("intersection modified the shared base")
+	}
+}
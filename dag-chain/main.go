@@ -1,45 +1,184 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Abdullah-zahoor/dagchain/api"
 	"github.com/Abdullah-zahoor/dagchain/block"
 	"github.com/Abdullah-zahoor/dagchain/consensus"
 	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+	"github.com/Abdullah-zahoor/dagchain/p2p"
 	"github.com/Abdullah-zahoor/dagchain/sim"
+	"github.com/Abdullah-zahoor/dagchain/store"
 	"github.com/Abdullah-zahoor/dagchain/viz"
 )
 
 func main() {
+	utxoLog := flag.String("utxo-log", "", "keep UTXO state in this append-only log instead of memory")
+	k := flag.Int("k", 18, "GHOSTDAG k: how many blocks a blue block may have in its blue anticone")
+	depth := flag.Int("finality-depth", 6, "selected-chain blocks below the heaviest tip that are final")
+	data := flag.String("data", "", "keep blocks in this directory and restore them on restart")
+	listen := flag.String("listen", "", "gossip blocks and transactions with peers on this TCP address")
+	peers := flag.String("peers", "", "comma-separated addresses of peers to connect to")
+	httpAddr := flag.String("http", ":8080", "serve the HTTP API on this address")
+	seed := flag.Int64("seed", 1, "seed of the simulated network; a seed always mines the same blocks")
+	pow := flag.Uint64("pow", 0, "require proof of work with this minimum difficulty; 0 weighs blocks by tx count")
+	flag.Parse()
+
 	// --- Bootstrap & Simulation (unchanged) ---
 	d := dag.NewDAG()
-	genesis := &block.Block{ID: "genesis", Parents: nil, TXs: nil, Timestamp: time.Now()}
-	initialUTXO := make(block.UTXOSet)
-	if err := d.AddGenesis(genesis, initialUTXO); err != nil {
-		panic(err)
+	d.Merge = consensus.GhostDAG{K: *k}
+	d.Params = dag.DefaultParams()
+	if *pow > 0 {
+		d.Params.ProofOfWork = true
+		d.Params.MinDifficulty = *pow
+	}
+	var blocks *store.BlockStore
+	if *data != "" {
+		var err error
+		if blocks, err = store.OpenBlockStore(*data); err != nil {
+			panic(err)
+		}
+		defer blocks.Close()
+	}
+	if blocks != nil && !blocks.Empty() {
+		loaded, err := blocks.Load(d)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("♻️ Restored %d blocks from %s (%d replayed)\n", loaded.Blocks, *data, loaded.Replayed)
+	} else {
+		// a fixed genesis lets separate processes gossip as one network
+		genesis := block.NewBlock(nil, nil, time.Unix(1700000000, 0))
+		var initialUTXO block.UTXOStore = block.NewUTXOTrie()
+		if *utxoLog != "" {
+			logStore, err := store.OpenLogStore(*utxoLog)
+			if err != nil {
+				panic(err)
+			}
+			defer logStore.Close()
+			initialUTXO = logStore
+		}
+		if err := d.AddGenesis(genesis, initialUTXO); err != nil {
+			panic(err)
+		}
+		if blocks != nil {
+			// checkpoint first: a crash before the put leaves an empty log,
+			// which starts over from here, rather than a log Load rejects
+			if err := blocks.Checkpoint(d.Genesis()); err != nil {
+				panic(err)
+			}
+			if err := blocks.Put(d.Genesis()); err != nil {
+				panic(err)
+			}
+		}
+		fmt.Println("✅ Genesis added")
+	}
+	if blocks != nil {
+		stop := blocks.Follow(d, 64, func(err error) {
+			fmt.Println("⚠️ Block store stopped persisting:", err)
+		})
+		defer stop()
+	}
+
+	var node *p2p.Node
+	if *listen != "" || *peers != "" {
+		var pool *mempool.Pool
+		if tip := d.HeaviestTip(); tip.UTXO != nil {
+			var err error
+			if pool, err = mempool.New(tip.UTXO); err != nil {
+				panic(err)
+			}
+		} else {
+			// a restored tip on a branch that forked below the checkpoint
+			fmt.Println("⚠️ Heaviest tip has no UTXO state; not relaying transactions")
+		}
+		node = p2p.New(d, pool, p2p.Config{})
+		defer node.Close()
+		if *listen != "" {
+			if err := node.Listen(*listen); err != nil {
+				panic(err)
+			}
+			fmt.Println("📡 Gossiping on", node.Addr())
+		}
+		for _, addr := range strings.Split(*peers, ",") {
+			if addr == "" {
+				continue
+			}
+			if _, err := node.Connect(addr); err != nil {
+				fmt.Println("⚠️ Peer:", err)
+			}
+		}
 	}
-	fmt.Println("✅ Genesis added")
 
-	simulator := sim.NewSimulator(d)
-	fmt.Println("▶️ Starting simulation of 3 validators for 5s…")
-	simulator.Run(3, 5*time.Second)
-	fmt.Println("⏹ Simulation complete")
+	events := d.Subscribe(64)
+	go func() {
+		for ev := range events.C {
+			if ev.Kind == dag.EventReorg {
+				fmt.Printf("🔀 Reorg at %s: %d blocks disconnected, %d connected\n",
+					ev.Reorg.Fork.Block.ID, len(ev.Reorg.Disconnected), len(ev.Reorg.Connected))
+			}
+		}
+	}()
+
+	fmt.Println("▶️ Starting simulation of 3 validators for 5s of network time…")
+	params := d.Params
+	network, err := sim.NewNetwork(sim.NetConfig{
+		Validators:    3,
+		Seed:          *seed,
+		BlockInterval: 100 * time.Millisecond,
+		Link:          sim.Link{Latency: 50 * time.Millisecond, Jitter: 100 * time.Millisecond},
+		Merge:         d.Merge,
+		Params:        &params,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := network.RunFor(5 * time.Second); err != nil {
+		panic(err)
+	}
+	if _, err := network.Settle(time.Minute); err != nil {
+		panic(err)
+	}
+	// the first validator's blocks join d, and through it the store and peers
+	added := 0
+	view := network.Validators()[0].DAG.View()
+	for _, n := range view.AntiPast(view.FindFork(d.Locator())) {
+		if d.Node(n.Block.ID) != nil {
+			continue
+		}
+		if err := d.AddBlock(n.Block); err != nil {
+			fmt.Println("⚠️ Simulation:", err)
+			break
+		}
+		added++
+	}
+	fmt.Printf("⏹ Simulation complete: %d blocks added\n", added)
 
 	// --- Consensus & Visualization (unchanged) ---
 	if tip := consensus.HeaviestTip(d); tip != nil {
-		fmt.Printf("🏆 Heaviest tip: %s (weight=%d)\n", tip.Block.ID, tip.Weight)
+		fmt.Printf("🏆 Heaviest tip: %s (blue score=%d, weight=%d)\n", tip.Block.ID, tip.BlueScore, tip.Weight)
+	}
+	finality := consensus.NewFinality(consensus.Depth{N: *depth})
+	finality.Update(d)
+	fmt.Printf("🔒 Finalized: %v\n", finality.Finalized())
+	archive := make(consensus.MemArchive)
+	pruned, err := consensus.Prune(d, finality, archive)
+	if err != nil {
+		panic(err)
 	}
-	consensus.PruneBranches(d)
+	fmt.Printf("🔪 Archived %d blocks below the finality point (%d orphaned txs)\n", len(pruned.Blocks), len(pruned.Orphaned))
 	fmt.Print("Remaining nodes:")
-	for id := range d.Nodes {
+	for id := range d.View().Nodes() {
 		fmt.Printf(" %s", id)
 	}
 	fmt.Println()
-	fmt.Printf("🔒 Finalized: %v\n", consensus.Finalized(d))
 
 	// dump dot
 	if err := os.WriteFile("dag.dot", []byte(viz.DOT(d)), 0o644); err != nil {
@@ -48,23 +187,9 @@ func main() {
 	fmt.Println("· Wrote dag.dot (use `dot -Tpng dag.dot -o dag.png`)")
 
 	// --- HTTP API ---
-	http.HandleFunc("/tips", func(w http.ResponseWriter, r *http.Request) {
-		tips := consensus.HeaviestTip(d)
-		json.NewEncoder(w).Encode(tips)
-	})
-	http.HandleFunc("/finalized", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(consensus.Finalized(d))
-	})
-	http.HandleFunc("/ascii", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, viz.ASCII(d))
-	})
-	http.HandleFunc("/dot", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/vnd.graphviz")
-		fmt.Fprint(w, viz.DOT(d))
-	})
-
-	fmt.Println("🚀 HTTP API listening on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	srv := &api.Server{DAG: d, Finality: finality, Node: node}
+	fmt.Println("🚀 HTTP API listening on", *httpAddr)
+	if err := http.ListenAndServe(*httpAddr, srv.Handler()); err != nil {
 		panic(err)
 	}
 }
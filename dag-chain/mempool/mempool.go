@@ -0,0 +1,249 @@
+// Package mempool holds transactions waiting to be included in a block.
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// Admission failures. Validation failures against the tip view are
+// returned as the block package's errors.
+var (
+	ErrCoinbase  = errors.New("mempool: coinbase transactions are not relayed")
+	ErrDuplicate = errors.New("mempool: transaction already in pool")
+	ErrConflict  = errors.New("mempool: spends an output another pooled transaction spends")
+)
+
+// Evicted is a transaction dropped from the pool by Update.
+type Evicted struct {
+	Tx  block.TX
+	Err error
+}
+
+type entry struct {
+	tx      block.TX
+	fee     uint64
+	size    int
+	seq     uint64              // admission order; parents before children
+	parents map[string]struct{} // pooled txs this one spends from
+}
+
+// Pool validates transactions against the UTXO view of the current tip
+// plus every pooled transaction, so a transaction may spend outputs of
+// another one still in the pool. It is safe for concurrent use.
+type Pool struct {
+	mu     sync.Mutex
+	state  block.UTXOStore // tip with all pooled txs applied
+	txs    map[string]*entry
+	spends map[block.UTXOKey]string // outpoint -> pooled tx spending it
+	seq    uint64
+}
+
+// New returns an empty pool on top of the tip's UTXO view.
+func New(tip block.UTXOStore) (*Pool, error) {
+	p := &Pool{}
+	if err := p.reset(tip); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pool) reset(tip block.UTXOStore) error {
+	state, err := tip.Snapshot()
+	if err != nil {
+		return err
+	}
+	p.state = state
+	p.txs = make(map[string]*entry)
+	p.spends = make(map[block.UTXOKey]string)
+	return nil
+}
+
+// Add validates tx and admits it. A transaction that spends an output
+// some pooled transaction already spends is refused with a
+// *block.ConflictError wrapping ErrConflict; the first one seen wins.
+func (p *Pool) Add(tx block.TX) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.add(tx)
+}
+
+func (p *Pool) add(tx block.TX) error {
+	if tx.IsCoinbase() {
+		return ErrCoinbase
+	}
+	if _, dup := p.txs[tx.ID]; dup {
+		return fmt.Errorf("%w: %s", ErrDuplicate, tx.ID)
+	}
+	for _, in := range tx.Inputs {
+		key := block.UTXOKey{TxID: in.PrevTxID, OutIndex: in.OutputIndex}
+		if other, ok := p.spends[key]; ok {
+			return &block.ConflictError{Outpoint: key, FirstTx: other, SecondTx: tx.ID, Err: ErrConflict}
+		}
+	}
+	undo, err := block.ApplyTx(p.state, tx)
+	if err != nil {
+		return err
+	}
+
+	e := &entry{tx: tx, fee: undo.Fee, size: tx.Size(), seq: p.seq, parents: make(map[string]struct{})}
+	p.seq++
+	for _, in := range tx.Inputs {
+		p.spends[block.UTXOKey{TxID: in.PrevTxID, OutIndex: in.OutputIndex}] = tx.ID
+		if _, pooled := p.txs[in.PrevTxID]; pooled {
+			e.parents[in.PrevTxID] = struct{}{}
+		}
+	}
+	p.txs[tx.ID] = e
+	return nil
+}
+
+// Len returns the number of pooled transactions.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.txs)
+}
+
+// Get returns a pooled transaction by ID.
+func (p *Pool) Get(id string) (block.TX, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.txs[id]
+	if !ok {
+		return block.TX{}, false
+	}
+	return e.tx, true
+}
+
+// Update moves the pool onto a new tip view. Every pooled transaction is
+// re-validated in admission order; those the new tip already confirms or
+// conflicts with, and any that spent from them, are evicted and returned.
+func (p *Pool) Update(tip block.UTXOStore) ([]Evicted, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.sorted()
+	if err := p.reset(tip); err != nil {
+		return nil, err
+	}
+	var evicted []Evicted
+	for _, e := range old {
+		if err := p.add(e.tx); err != nil {
+			if !admissionFailure(err) {
+				return evicted, err
+			}
+			evicted = append(evicted, Evicted{Tx: e.tx, Err: err})
+		}
+	}
+	return evicted, nil
+}
+
+// Readmit offers the transactions of blocks that left the DAG, such as
+// those returned by consensus.Prune, back to the pool. Blocks must
+// be in topological order. Coinbases and transactions no longer valid on
+// the tip are skipped; the number admitted is returned.
+func (p *Pool) Readmit(blocks []*block.Block) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	admitted := 0
+	for _, b := range blocks {
+		for _, tx := range b.TXs {
+			err := p.add(tx)
+			if err == nil {
+				admitted++
+				continue
+			}
+			if !admissionFailure(err) {
+				return admitted, err
+			}
+		}
+	}
+	return admitted, nil
+}
+
+// admissionFailure reports whether err rejects a transaction, as opposed
+// to a storage failure.
+func admissionFailure(err error) bool {
+	return block.IsInvalid(err) || errors.Is(err, ErrCoinbase) ||
+		errors.Is(err, ErrDuplicate) || errors.Is(err, ErrConflict)
+}
+
+func (p *Pool) sorted() []*entry {
+	out := make([]*entry, 0, len(p.txs))
+	for _, e := range p.txs {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out
+}
+
+// Select returns up to maxTxs transactions totalling at most maxBytes,
+// highest fee rate first, with every transaction after the pooled ones it
+// spends from. A limit of 0 means no limit. The pool is not modified.
+func (p *Pool) Select(maxTxs, maxBytes int) []block.TX {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := make(map[string]int, len(p.txs))
+	children := make(map[string][]*entry)
+	ready := &feeHeap{}
+	for _, e := range p.txs {
+		pending[e.tx.ID] = len(e.parents)
+		for parent := range e.parents {
+			children[parent] = append(children[parent], e)
+		}
+		if len(e.parents) == 0 {
+			ready.entries = append(ready.entries, e)
+		}
+	}
+	heap.Init(ready)
+
+	var out []block.TX
+	used := 0
+	for ready.Len() > 0 && (maxTxs == 0 || len(out) < maxTxs) {
+		e := heap.Pop(ready).(*entry)
+		if maxBytes > 0 && used+e.size > maxBytes {
+			continue // its descendants stay blocked too
+		}
+		out = append(out, e.tx)
+		used += e.size
+		for _, c := range children[e.tx.ID] {
+			if pending[c.tx.ID]--; pending[c.tx.ID] == 0 {
+				heap.Push(ready, c)
+			}
+		}
+	}
+	return out
+}
+
+// feeHeap orders entries by fee rate, highest first, then admission order.
+type feeHeap struct{ entries []*entry }
+
+func (h *feeHeap) Len() int { return len(h.entries) }
+func (h *feeHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	// compare a.fee/a.size with b.fee/b.size in 128 bits
+	ahi, alo := bits.Mul64(a.fee, uint64(b.size))
+	bhi, blo := bits.Mul64(b.fee, uint64(a.size))
+	if ahi != bhi {
+		return ahi > bhi
+	}
+	if alo != blo {
+		return alo > blo
+	}
+	return a.seq < b.seq
+}
+func (h *feeHeap) Swap(i, j int) { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *feeHeap) Push(x any)    { h.entries = append(h.entries, x.(*entry)) }
+func (h *feeHeap) Pop() any {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+	h.entries = h.entries[:n]
+	return e
+}
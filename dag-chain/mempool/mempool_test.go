@@ -0,0 +1,151 @@
+package mempool_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+)
+
+func testKey(name string) *block.KeyPair {
+	seed := sha256.Sum256([]byte(name))
+	return block.KeyFromSeed(seed[:])
+}
+
+var alice = testKey("alice")
+
+// spend signs a tx moving prev to alice, keeping value - fee.
+func spend(prev block.UTXOKey, value, fee uint64, extra string) block.TX {
+	tx := block.NewTX([]block.TXInput{{PrevTxID: prev.TxID, OutputIndex: prev.OutIndex}},
+		[]block.TXOutput{{Value: value - fee, Recipient: alice.Address()}}, []byte(extra))
+	tx.Sign(0, alice)
+	return tx
+}
+
+func tipWith(coins ...block.UTXOKey) *block.UTXOTrie {
+	tip := block.NewUTXOTrie()
+	for _, c := range coins {
+		tip.Put(c, block.TXOutput{Value: 100, Recipient: alice.Address()})
+	}
+	return tip
+}
+
+func TestAdd_ChainsAndConflicts(t *testing.T) {
+	c0 := block.UTXOKey{TxID: "c0"}
+	pool, err := mempool.New(tipWith(c0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := spend(c0, 100, 1, "")
+	if err := pool.Add(parent); err != nil {
+		t.Fatalf("Add parent: %v", err)
+	}
+	// A child spending the pooled parent is accepted
+	child := spend(block.UTXOKey{TxID: parent.ID}, 99, 1, "")
+	if err := pool.Add(child); err != nil {
+		t.Fatalf("Add child: %v", err)
+	}
+	if err := pool.Add(parent); !errors.Is(err, mempool.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	rival := spend(c0, 100, 5, "rival")
+	err = pool.Add(rival)
+	var conflict *block.ConflictError
+	if !errors.Is(err, mempool.ErrConflict) || !errors.As(err, &conflict) || conflict.FirstTx != parent.ID {
+		t.Errorf("expected conflict with %s, got %v", parent.ID, err)
+	}
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1}}, nil)
+	if err := pool.Add(cb); !errors.Is(err, mempool.ErrCoinbase) {
+		t.Errorf("expected ErrCoinbase, got %v", err)
+	}
+	bad := spend(block.UTXOKey{TxID: "nowhere"}, 100, 1, "")
+	if err := pool.Add(bad); !errors.Is(err, block.ErrMissingInput) {
+		t.Errorf("expected ErrMissingInput, got %v", err)
+	}
+}
+
+func TestSelect_FeeRateAndDependencies(t *testing.T) {
+	c0, c1, c2 := block.UTXOKey{TxID: "c0"}, block.UTXOKey{TxID: "c1"}, block.UTXOKey{TxID: "c2"}
+	pool, _ := mempool.New(tipWith(c0, c1, c2))
+
+	low := spend(c0, 100, 1, "")
+	high := spend(c1, 100, 50, "")
+	mid := spend(c2, 100, 10, "")
+	// the child pays a high fee but must follow its low-fee parent
+	child := spend(block.UTXOKey{TxID: low.ID}, 99, 90, "")
+	for _, tx := range []block.TX{low, high, mid, child} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := pool.Select(0, 0)
+	want := []string{high.ID, mid.ID, low.ID, child.ID}
+	if len(got) != len(want) {
+		t.Fatalf("selected %d txs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i] {
+			t.Fatalf("position %d: got %s, want %s", i, got[i].ID, want[i])
+		}
+	}
+	if got := pool.Select(2, 0); len(got) != 2 {
+		t.Errorf("count limit ignored: %d", len(got))
+	}
+	if got := pool.Select(0, high.Size()); len(got) != 1 || got[0].ID != high.ID {
+		t.Errorf("size limit ignored: %v", got)
+	}
+}
+
+func TestUpdateAndReadmit(t *testing.T) {
+	c0, c1 := block.UTXOKey{TxID: "c0"}, block.UTXOKey{TxID: "c1"}
+	tip := tipWith(c0, c1)
+	pool, _ := mempool.New(tip)
+
+	confirmed := spend(c0, 100, 1, "")
+	dependent := spend(block.UTXOKey{TxID: confirmed.ID}, 99, 1, "")
+	loser := spend(c1, 100, 1, "loser")
+	for _, tx := range []block.TX{confirmed, dependent, loser} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The new tip confirms `confirmed` and spends c1 elsewhere
+	next, _ := tip.Snapshot()
+	if _, err := block.ApplyTx(next, confirmed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := block.ApplyTx(next, spend(c1, 100, 1, "winner")); err != nil {
+		t.Fatal(err)
+	}
+	evicted, err := pool.Update(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || pool.Len() != 1 {
+		t.Fatalf("expected confirmed and loser evicted, got %d evicted, %d left", len(evicted), pool.Len())
+	}
+	if _, ok := pool.Get(dependent.ID); !ok {
+		t.Error("dependent of a confirmed tx should stay")
+	}
+
+	// Back on the old tip, a pruned block's txs come back
+	if _, err := pool.Update(tip); err != nil {
+		t.Fatal(err)
+	}
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 50}}, nil)
+	pruned := block.NewBlock(nil, []block.TX{cb, confirmed}, time.Unix(0, 0))
+	n, err := pool.Readmit([]*block.Block{pruned})
+	if err != nil || n != 1 {
+		t.Errorf("Readmit = %d, %v; want 1", n, err)
+	}
+	if _, ok := pool.Get(confirmed.ID); !ok {
+		t.Error("pruned tx not readmitted")
+	}
+}
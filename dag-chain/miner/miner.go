@@ -0,0 +1,159 @@
+// Package miner builds block templates on top of a DAG for validators and
+// network nodes to propose.
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// TxSource supplies candidate transactions, in the order they should be
+// included. *mempool.Pool implements it.
+type TxSource interface {
+	Select(maxTxs, maxBytes int) []block.TX
+}
+
+// ParentPolicy picks the parents of the next block.
+type ParentPolicy interface {
+	Parents(d *dag.DAG) []*dag.Node
+}
+
+// HeaviestTips selects up to Max tips, heaviest first as ranked by
+// dag.Heavier. Max <= 0 means 1.
+type HeaviestTips struct {
+	Max int
+}
+
+func (p HeaviestTips) Parents(d *dag.DAG) []*dag.Node {
+	tips := consensus.Tips(d)
+	limit := max(p.Max, 1)
+	if len(tips) > limit {
+		tips = tips[:limit]
+	}
+	return tips
+}
+
+// Config controls template construction.
+type Config struct {
+	Parents  ParentPolicy   // nil means HeaviestTips{Max: 1}
+	Source   TxSource       // nil builds coinbase-only blocks
+	MaxTxs   int            // per block, coinbase included; 0 means no limit
+	MaxBytes int            // total tx size, coinbase included; 0 means no limit
+	Payout   string         // address the coinbase pays
+	Key      *block.KeyPair // signs blocks as their Producer; nil builds anonymous blocks
+	MaxTries uint64         // nonces to try under proof of work; 0 means no limit
+}
+
+// Build failures.
+var (
+	ErrNoParents = errors.New("miner: no tips to build on")
+	ErrNotMined  = errors.New("miner: no nonce within MaxTries meets the difficulty")
+)
+
+// Builder assembles block templates.
+type Builder struct {
+	DAG    *dag.DAG
+	Config Config
+}
+
+// New returns a Builder for d.
+func New(d *dag.DAG, cfg Config) *Builder {
+	return &Builder{DAG: d, Config: cfg}
+}
+
+// Build returns a block on the policy's parents with transactions from the
+// source and a coinbase claiming the subsidy plus fees. Transactions that
+// do not apply on the merged parent state are left out. extra is copied
+// into the coinbase to tell apart blocks built on the same parents. The
+// header commits to the UTXO set the block leaves behind. When the DAG
+// requires proof of work, the block is mined before it is returned, and
+// signed after that when the config has a Key.
+func (b *Builder) Build(ts time.Time, extra []byte) (*block.Block, error) {
+	policy := b.Config.Parents
+	if policy == nil {
+		policy = HeaviestTips{Max: 1}
+	}
+	parents := policy.Parents(b.DAG)
+	if len(parents) == 0 {
+		return nil, ErrNoParents
+	}
+	parentIDs := make([]string, len(parents))
+	var height uint64
+	for i, p := range parents {
+		parentIDs[i] = p.Block.ID
+		height = max(height, p.Height+1)
+	}
+
+	// The coinbase encodes its value in fixed width, so its size is known
+	// before the fees are.
+	cbExtra := append([]byte(strings.Join(parentIDs, ",")), extra...)
+	coinbase := block.NewTX(nil, []block.TXOutput{{Recipient: b.Config.Payout}}, cbExtra)
+	maxTxs, maxBytes, room := 0, 0, true
+	if b.Config.MaxTxs > 0 {
+		maxTxs = b.Config.MaxTxs - 1
+		room = maxTxs > 0
+	}
+	if b.Config.MaxBytes > 0 {
+		maxBytes = b.Config.MaxBytes - coinbase.Size()
+		room = room && maxBytes > 0
+	}
+
+	merged, err := b.DAG.MergeParents(parents)
+	if err != nil {
+		return nil, fmt.Errorf("miner: merge parents: %w", err)
+	}
+	var txs []block.TX
+	reward := block.Subsidy(height)
+	if b.Config.Source != nil && room {
+		for _, tx := range b.Config.Source.Select(maxTxs, maxBytes) {
+			undo, err := block.ApplyTx(merged.State, tx)
+			if err != nil {
+				if block.IsInvalid(err) {
+					continue
+				}
+				return nil, err
+			}
+			sum, carry := bits.Add64(reward, undo.Fee, 0)
+			if carry != 0 {
+				break
+			}
+			txs = append(txs, tx)
+			reward = sum
+		}
+	}
+
+	coinbase.Outputs[0].Value = reward
+	coinbase.ID = coinbase.Hash()
+	for i, out := range coinbase.Outputs {
+		if err := merged.State.Put(block.UTXOKey{TxID: coinbase.ID, OutIndex: i}, out); err != nil {
+			return nil, err
+		}
+	}
+	blk := block.NewBlock(parentIDs, append([]block.TX{coinbase}, txs...), ts)
+	blk.UTXORoot = merged.State.Root()
+	if b.Config.Key != nil {
+		blk.Producer = b.Config.Key.Public
+	}
+	blk.ID = blk.Hash()
+	if b.DAG.Params.ProofOfWork {
+		blk.Difficulty = b.DAG.NextDifficulty(parents)
+		tries := b.Config.MaxTries
+		if tries == 0 {
+			tries = ^uint64(0)
+		}
+		if !blk.Mine(tries) {
+			return nil, ErrNotMined
+		}
+	}
+	if b.Config.Key != nil {
+		blk.Sign(b.Config.Key)
+	}
+	return blk, nil
+}
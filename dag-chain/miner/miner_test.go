@@ -0,0 +1,136 @@
+package miner_test
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+	"github.com/Abdullah-zahoor/dagchain/miner"
+)
+
+var ts = time.Unix(1700000000, 0)
+
+func testKey(name string) *block.KeyPair {
+	seed := sha256.Sum256([]byte(name))
+	return block.KeyFromSeed(seed[:])
+}
+
+// forkedDAG returns a DAG with a premined coin for alice and three
+// branches from genesis holding 0, 1 and 2 coinbase-only blocks, so their
+// tips weigh 0, 1 and 2.
+func forkedDAG(t *testing.T, alice *block.KeyPair) (*dag.DAG, block.UTXOKey) {
+	t.Helper()
+	d := dag.NewDAG()
+	gen := block.NewBlock(nil, nil, ts)
+	utxo := block.NewUTXOTrie()
+	coin := block.UTXOKey{TxID: "premine"}
+	utxo.Put(coin, block.TXOutput{Value: 100, Recipient: alice.Address()})
+	if err := d.AddGenesis(gen, utxo); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddBlock(block.NewBlock([]string{gen.ID}, nil, ts.Add(1))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 3; i++ {
+		tip := gen.ID
+		for j := 0; j < i; j++ {
+			cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "m"}}, []byte{byte(i), byte(j)})
+			b := block.NewBlock([]string{tip}, []block.TX{cb}, ts.Add(time.Duration(i+1)))
+			if err := d.AddBlock(b); err != nil {
+				t.Fatal(err)
+			}
+			tip = b.ID
+		}
+	}
+	return d, coin
+}
+
+func TestBuild_ParentsFeesAndLimits(t *testing.T) {
+	alice, miner1 := testKey("alice"), testKey("miner")
+	d, coin := forkedDAG(t, alice)
+
+	pool, err := mempool.New(d.Genesis().UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pay := block.NewTX([]block.TXInput{{PrevTxID: coin.TxID}},
+		[]block.TXOutput{{Value: 93, Recipient: alice.Address()}}, nil)
+	pay.Sign(0, alice) // fee 7
+	if err := pool.Add(pay); err != nil {
+		t.Fatal(err)
+	}
+
+	b := miner.New(d, miner.Config{
+		Parents: miner.HeaviestTips{Max: 2},
+		Source:  pool,
+		Payout:  miner1.Address(),
+	})
+	blk, err := b.Build(ts.Add(time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blk.Parents) != 2 {
+		t.Fatalf("expected 2 parents, got %d", len(blk.Parents))
+	}
+	for _, pid := range blk.Parents {
+		if d.Node(pid).Weight == 0 {
+			t.Error("lightest tip selected as parent")
+		}
+	}
+	if len(blk.TXs) != 2 || blk.TXs[1].ID != pay.ID {
+		t.Fatalf("expected coinbase + pooled tx, got %d txs", len(blk.TXs))
+	}
+	if got, want := blk.TXs[0].Outputs[0].Value, block.Subsidy(3)+7; got != want {
+		t.Errorf("coinbase pays %d, want %d", got, want)
+	}
+	if err := d.AddBlock(blk); err != nil {
+		t.Fatalf("template rejected by DAG: %v", err)
+	}
+
+	// A count limit of one leaves room for the coinbase only
+	b.Config.MaxTxs = 1
+	blk, err = b.Build(ts.Add(2*time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blk.TXs) != 1 || !blk.TXs[0].IsCoinbase() {
+		t.Errorf("expected coinbase-only block, got %d txs", len(blk.TXs))
+	}
+}
+
+func TestBuild_MinesUnderProofOfWork(t *testing.T) {
+	d := dag.NewDAG()
+	d.Params = dag.DefaultParams()
+	d.Params.ProofOfWork = true
+	d.Params.MinDifficulty = 64
+	d.Params.Now = func() time.Time { return ts.Add(time.Hour) }
+	if err := d.AddGenesis(block.NewBlock(nil, nil, ts), block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	b := miner.New(d, miner.Config{Payout: testKey("miner").Address()})
+	for i := 1; i <= 3; i++ {
+		blk, err := b.Build(ts.Add(time.Duration(i)*time.Second), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk.Difficulty != 64 || !blk.MeetsTarget() {
+			t.Fatalf("block %d: difficulty %d, meets target %v", i, blk.Difficulty, blk.MeetsTarget())
+		}
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatalf("mined block rejected: %v", err)
+		}
+	}
+	if w := d.HeaviestTip().Weight; w != 3*64 {
+		t.Errorf("tip weight %d, want 192", w)
+	}
+
+	// one try is very unlikely to meet a high difficulty
+	d.Params.MinDifficulty = 1 << 40
+	b.Config.MaxTries = 1
+	if _, err := b.Build(ts.Add(time.Minute), nil); err != miner.ErrNotMined {
+		t.Errorf("expected ErrNotMined, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Package orphan holds blocks that arrived before their parents and
+// connects them to the DAG once the parents are in.
+package orphan
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// Reasons for dropping an orphan under the pool's limits.
+var (
+	ErrExpired = errors.New("orphan: expired before its parents arrived")
+	ErrEvicted = errors.New("orphan: evicted to stay within MaxOrphans")
+)
+
+// Config limits the pool.
+type Config struct {
+	MaxOrphans int              // 0 means no limit; the oldest is evicted first
+	MaxAge     time.Duration    // 0 means orphans never expire
+	Now        func() time.Time // nil means time.Now
+}
+
+// Dropped is an orphan removed from the pool without being connected.
+type Dropped struct {
+	Block *block.Block
+	Err   error
+}
+
+type orphan struct {
+	blk     *block.Block
+	added   time.Time
+	missing map[string]struct{} // parents not yet in the DAG
+}
+
+// Pool holds orphan blocks indexed by the parents they wait for. Blocks
+// should reach the DAG through Add, as an orphan only wakes up when its
+// last missing parent is added here. It is safe for concurrent use.
+type Pool struct {
+	mu      sync.Mutex
+	dag     *dag.DAG
+	cfg     Config
+	orphans map[string]*orphan
+	waiting map[string]map[string]struct{} // missing parent -> orphans
+	// age queues orphans oldest first. Ones connected or dropped out of
+	// turn stay queued until they reach the front or the queue is
+	// compacted.
+	age []*orphan
+}
+
+// New returns an empty pool feeding d.
+func New(d *dag.DAG, cfg Config) *Pool {
+	return &Pool{
+		dag:     d,
+		cfg:     cfg,
+		orphans: make(map[string]*orphan),
+		waiting: make(map[string]map[string]struct{}),
+	}
+}
+
+// Add connects blk if all its parents are in the DAG, then connects every
+// orphan that was waiting on it, recursively. If parents are missing, blk
+// is held instead and nothing is connected. The connected blocks are
+// returned parents first, along with orphans dropped on the way: ones that
+// turned out invalid, their descendants, and ones evicted by the limits.
+// err is only about blk itself.
+func (p *Pool) Add(blk *block.Block) (connected []*block.Block, dropped []Dropped, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	dropped = p.expire(now)
+
+	if blk.ID != blk.Hash() {
+		return nil, dropped, fmt.Errorf("block %s: %w", blk.ID, block.ErrIDMismatch)
+	}
+	if err := blk.CheckTxRoot(); err != nil {
+		return nil, dropped, err
+	}
+	if err := blk.CheckSignature(); err != nil {
+		return nil, dropped, err
+	}
+	if _, held := p.orphans[blk.ID]; held {
+		return nil, dropped, nil
+	}
+	missing := make(map[string]struct{})
+	for _, pid := range blk.Parents {
+		if p.dag.Node(pid) == nil {
+			missing[pid] = struct{}{}
+		}
+	}
+	if len(missing) > 0 {
+		p.hold(&orphan{blk: blk, added: now, missing: missing})
+		return nil, append(dropped, p.evict()...), nil
+	}
+
+	if err := p.dag.AddBlock(blk); err != nil {
+		return nil, dropped, err
+	}
+	connected = []*block.Block{blk}
+	for i := 0; i < len(connected); i++ {
+		id := connected[i].ID
+		waiters := sortedKeys(p.waiting[id])
+		delete(p.waiting, id)
+		for _, oid := range waiters {
+			o, ok := p.orphans[oid]
+			if !ok {
+				continue
+			}
+			delete(o.missing, id)
+			if len(o.missing) > 0 {
+				continue
+			}
+			delete(p.orphans, oid)
+			if err := p.dag.AddBlock(o.blk); err != nil {
+				dropped = append(dropped, p.dropDescendants(o.blk, err)...)
+				continue
+			}
+			connected = append(connected, o.blk)
+		}
+	}
+	return connected, dropped, nil
+}
+
+// Missing returns the sorted IDs of blocks that orphans wait for and that
+// are not orphans themselves, so a sync layer can request them.
+func (p *Pool) Missing() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for id := range p.waiting {
+		if _, held := p.orphans[id]; !held {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Len returns the number of orphans held.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.orphans)
+}
+
+// Has reports whether the block is held as an orphan.
+func (p *Pool) Has(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.orphans[id]
+	return ok
+}
+
+// Expire drops orphans older than MaxAge and returns them.
+func (p *Pool) Expire() []Dropped {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expire(p.now())
+}
+
+func (p *Pool) now() time.Time {
+	if p.cfg.Now != nil {
+		return p.cfg.Now()
+	}
+	return time.Now()
+}
+
+func (p *Pool) hold(o *orphan) {
+	if len(p.age) > 2*len(p.orphans) {
+		p.age = slices.DeleteFunc(p.age, func(q *orphan) bool { return !p.holds(q) })
+	}
+	p.age = append(p.age, o)
+	p.orphans[o.blk.ID] = o
+	for pid := range o.missing {
+		if p.waiting[pid] == nil {
+			p.waiting[pid] = make(map[string]struct{})
+		}
+		p.waiting[pid][o.blk.ID] = struct{}{}
+	}
+}
+
+func (p *Pool) remove(o *orphan) {
+	delete(p.orphans, o.blk.ID)
+	for pid := range o.missing {
+		delete(p.waiting[pid], o.blk.ID)
+		if len(p.waiting[pid]) == 0 {
+			delete(p.waiting, pid)
+		}
+	}
+}
+
+func (p *Pool) expire(now time.Time) []Dropped {
+	if p.cfg.MaxAge <= 0 {
+		return nil
+	}
+	var dropped []Dropped
+	for o := p.oldest(); o != nil && now.Sub(o.added) > p.cfg.MaxAge; o = p.oldest() {
+		p.remove(o)
+		dropped = append(dropped, Dropped{Block: o.blk, Err: ErrExpired})
+	}
+	return dropped
+}
+
+func (p *Pool) evict() []Dropped {
+	if p.cfg.MaxOrphans <= 0 || len(p.orphans) <= p.cfg.MaxOrphans {
+		return nil
+	}
+	var dropped []Dropped
+	for len(p.orphans) > p.cfg.MaxOrphans {
+		o := p.oldest()
+		p.remove(o)
+		dropped = append(dropped, Dropped{Block: o.blk, Err: ErrEvicted})
+	}
+	return dropped
+}
+
+// dropDescendants drops the orphans that wait on a block that failed to
+// connect, since they never can.
+func (p *Pool) dropDescendants(failed *block.Block, err error) []Dropped {
+	dropped := []Dropped{{Block: failed, Err: err}}
+	for i := 0; i < len(dropped); i++ {
+		id := dropped[i].Block.ID
+		for _, oid := range sortedKeys(p.waiting[id]) {
+			if o, ok := p.orphans[oid]; ok {
+				p.remove(o)
+				dropped = append(dropped, Dropped{Block: o.blk, Err: fmt.Errorf("parent %s dropped: %w", id, err)})
+			}
+		}
+		delete(p.waiting, id)
+	}
+	return dropped
+}
+
+// oldest returns the orphan held longest, or nil, first dequeuing the
+// ones that have left the pool.
+func (p *Pool) oldest() *orphan {
+	for len(p.age) > 0 {
+		if o := p.age[0]; p.holds(o) {
+			return o
+		}
+		p.age[0] = nil
+		p.age = p.age[1:]
+	}
+	return nil
+}
+
+// holds reports whether o is still in the pool, and not a block of the
+// same ID held again since.
+func (p *Pool) holds(o *orphan) bool {
+	return p.orphans[o.blk.ID] == o
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+package orphan_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/orphan"
+)
+
+var ts = time.Unix(1700000000, 0)
+
+func newDAG(t *testing.T) (*dag.DAG, *block.Block) {
+	t.Helper()
+	d := dag.NewDAG()
+	g := block.NewBlock(nil, nil, ts)
+	if err := d.AddGenesis(g, block.NewUTXOTrie()); err != nil {
+		t.Fatal(err)
+	}
+	return d, g
+}
+
+// child returns an empty block on parents, told apart by n.
+func child(n int, parents ...*block.Block) *block.Block {
+	ids := make([]string, len(parents))
+	for i, p := range parents {
+		ids[i] = p.ID
+	}
+	return block.NewBlock(ids, nil, ts.Add(time.Duration(n)*time.Second))
+}
+
+func ids(blocks []*block.Block) []string {
+	out := make([]string, len(blocks))
+	for i, b := range blocks {
+		out[i] = b.ID
+	}
+	return out
+}
+
+func TestConnectsOrphansRecursively(t *testing.T) {
+	d, g := newDAG(t)
+	p := orphan.New(d, orphan.Config{})
+	b1 := child(1, g)
+	b2 := child(2, b1)
+	b3 := child(3, b2)
+	x := child(4, g)
+	m := child(5, b3, x) // waits on two parents
+
+	for _, blk := range []*block.Block{m, b3, b2} {
+		if conn, _, err := p.Add(blk); err != nil || len(conn) != 0 {
+			t.Fatalf("orphan connected %d blocks (err %v)", len(conn), err)
+		}
+	}
+	want := []string{b1.ID, x.ID}
+	sort.Strings(want)
+	if got := p.Missing(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("missing %v, want %v", got, want)
+	}
+
+	conn, _, err := p.Add(b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ids([]*block.Block{b1, b2, b3}); !reflect.DeepEqual(ids(conn), want) {
+		t.Fatalf("connected %v, want %v", ids(conn), want)
+	}
+	if !p.Has(m.ID) || !reflect.DeepEqual(p.Missing(), []string{x.ID}) {
+		t.Fatal("m should still wait for x")
+	}
+
+	conn, _, err = p.Add(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ids([]*block.Block{x, m}); !reflect.DeepEqual(ids(conn), want) {
+		t.Fatalf("connected %v, want %v", ids(conn), want)
+	}
+	if p.Len() != 0 || d.Len() != 6 {
+		t.Errorf("pool holds %d, DAG has %d blocks", p.Len(), d.Len())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	d, g := newDAG(t)
+	now := ts
+	p := orphan.New(d, orphan.Config{MaxOrphans: 2, MaxAge: time.Minute, Now: func() time.Time { return now }})
+	missing := child(1, g)
+	o1, o2, o3 := child(2, missing), child(3, missing), child(4, missing)
+
+	p.Add(o1)
+	p.Add(o2)
+	_, dropped, _ := p.Add(o3)
+	if len(dropped) != 1 || dropped[0].Block.ID != o1.ID || !errors.Is(dropped[0].Err, orphan.ErrEvicted) {
+		t.Fatalf("dropped %v, want o1 evicted", dropped)
+	}
+
+	now = now.Add(2 * time.Minute)
+	dropped = p.Expire()
+	if len(dropped) != 2 || !errors.Is(dropped[0].Err, orphan.ErrExpired) || p.Len() != 0 {
+		t.Fatalf("expired %d orphans, %d left", len(dropped), p.Len())
+	}
+	if len(p.Missing()) != 0 {
+		t.Error("nothing should be missing once the pool is empty")
+	}
+
+	// the oldest orphan connects out of turn; the next oldest goes first
+	other := child(5, g)
+	o4, o5, o6, o7 := child(6, missing), child(7, other), child(8, other), child(9, other)
+	p.Add(o4)
+	p.Add(o5)
+	if connected, _, _ := p.Add(missing); !reflect.DeepEqual(ids(connected), ids([]*block.Block{missing, o4})) {
+		t.Fatalf("connected %v", ids(connected))
+	}
+	p.Add(o6)
+	_, dropped, _ = p.Add(o7)
+	if len(dropped) != 1 || dropped[0].Block.ID != o5.ID {
+		t.Fatalf("dropped %v, want o5 evicted", dropped)
+	}
+}
+
+func TestDropsInvalidOrphanAndDescendants(t *testing.T) {
+	d, g := newDAG(t)
+	p := orphan.New(d, orphan.Config{})
+	parent := child(1, g)
+	greedy := block.NewTX(nil, []block.TXOutput{{Value: block.InitialSubsidy + 1, Recipient: "m"}}, nil)
+	bad := block.NewBlock([]string{parent.ID}, []block.TX{greedy}, ts.Add(2*time.Second))
+	below := child(3, bad)
+
+	p.Add(below)
+	p.Add(bad)
+	conn, dropped, err := p.Add(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn) != 1 || len(dropped) != 2 || dropped[1].Block.ID != below.ID {
+		t.Fatalf("connected %d, dropped %d", len(conn), len(dropped))
+	}
+	if !errors.Is(dropped[1].Err, block.ErrCoinbaseTooLarge) || p.Len() != 0 {
+		t.Errorf("descendant dropped with %v, %d left", dropped[1].Err, p.Len())
+	}
+}
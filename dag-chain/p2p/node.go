@@ -0,0 +1,512 @@
+// Package p2p connects nodes that each own a DAG, gossiping blocks and
+// transactions over TCP.
+//
+// Peers open with a Version/VerAck handshake. After it, new blocks and
+// transactions are announced in Inv messages, and a peer asks for the
+// items it lacks with GetData. A block whose parents are missing is held
+// as an orphan. A node catches up with a peer by syncing: it sends a
+// locator of its selected chain, downloads the headers of the blocks
+// outside the past of the fork point, then fetches their bodies from every
+// peer in parallel and adds them in order. Peers older than SyncVersion
+// are asked for an orphan's parents instead. Peers collect misbehavior
+// points for invalid data and protocol violations, and are disconnected
+// and banned at Config.BanScore.
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+	"github.com/Abdullah-zahoor/dagchain/orphan"
+)
+
+// Protocol versions. A peer below MinProtocolVersion is refused; otherwise
+// the lower of the two versions is used.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// Connection failures.
+var (
+	ErrGenesisMismatch = errors.New("p2p: peer has a different genesis")
+	ErrOldVersion      = errors.New("p2p: peer protocol version too old")
+	ErrSelfConnect     = errors.New("p2p: connected to self")
+	ErrDuplicatePeer   = errors.New("p2p: already connected to peer")
+	ErrTooManyPeers    = errors.New("p2p: too many peers")
+	ErrBanned          = errors.New("p2p: peer banned")
+	ErrHandshake       = errors.New("p2p: unexpected message in handshake")
+	ErrSlowPeer        = errors.New("p2p: peer not reading its messages")
+	ErrUnsolicited     = errors.New("p2p: block sent without being requested")
+	ErrBadAfter        = errors.New("p2p: GetHeaders continues after a header not sent last")
+	ErrClosed          = errors.New("p2p: node closed")
+)
+
+// Config tunes a Node. Zero fields take the defaults noted.
+type Config struct {
+	MaxPeers         int           // 0 means 32
+	HandshakeTimeout time.Duration // 0 means 5s
+	RequestTimeout   time.Duration // before an item is asked of another peer; 0 means 30s
+	SendQueue        int           // messages queued per peer before it is dropped; 0 means 256
+	SendTimeout      time.Duration // how long a reply waits for room in the queue; 0 means 10s
+	BanScore         int           // misbehavior that gets a peer banned; 0 means 100
+	BanDuration      time.Duration // 0 means 24h
+	BanLocalHosts    bool          // ban loopback and private peers by host, not host:port
+	Orphans          orphan.Config // a zero MaxOrphans means 256, a zero MaxAge 10m
+	MaxSyncHeaders   int           // headers taken from the source in one sync round; 0 means 100000
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxPeers <= 0 {
+		c.MaxPeers = 32
+	}
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = 5 * time.Second
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = 30 * time.Second
+	}
+	if c.SendQueue <= 0 {
+		c.SendQueue = 256
+	}
+	if c.SendTimeout <= 0 {
+		c.SendTimeout = 10 * time.Second
+	}
+	if c.BanScore <= 0 {
+		c.BanScore = 100
+	}
+	if c.BanDuration <= 0 {
+		c.BanDuration = 24 * time.Hour
+	}
+	if c.MaxSyncHeaders <= 0 {
+		c.MaxSyncHeaders = 100000
+	}
+	if c.Orphans.MaxOrphans <= 0 {
+		c.Orphans.MaxOrphans = 256
+	}
+	if c.Orphans.MaxAge <= 0 {
+		c.Orphans.MaxAge = 10 * time.Minute
+	}
+	return c
+}
+
+// request is an item asked of a peer and not yet received.
+type request struct {
+	peer *Peer
+	at   time.Time
+}
+
+// Node gossips its DAG and mempool with peers. It relays every block
+// added to the DAG, whether received or built locally, and keeps the
+// mempool on the heaviest tip.
+type Node struct {
+	dag     *dag.DAG
+	mempool *mempool.Pool
+	orphans *orphan.Pool
+	sync    *syncer
+	cfg     Config
+	nonce   uint64
+	sub     *dag.Subscription
+
+	mu       sync.Mutex
+	ln       net.Listener
+	peers    map[*Peer]struct{}
+	banned   map[string]time.Time // banKey -> until
+	inflight map[InvItem]request
+	closed   bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns a node gossiping d, which must have its genesis. pool may be
+// nil, in which case transactions are neither relayed nor served.
+func New(d *dag.DAG, pool *mempool.Pool, cfg Config) *Node {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	cfg = cfg.withDefaults()
+	n := &Node{
+		dag:      d,
+		mempool:  pool,
+		orphans:  orphan.New(d, cfg.Orphans),
+		cfg:      cfg,
+		nonce:    binary.BigEndian.Uint64(nonce[:]),
+		sub:      d.Subscribe(64),
+		peers:    make(map[*Peer]struct{}),
+		banned:   make(map[string]time.Time),
+		inflight: make(map[InvItem]request),
+		quit:     make(chan struct{}),
+	}
+	n.sync = newSyncer(n)
+	n.wg.Add(2)
+	go n.relay()
+	go n.retry()
+	return n
+}
+
+// Listen accepts peers on addr, such as "127.0.0.1:0", until Close.
+func (n *Node) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	if n.closed || n.ln != nil {
+		n.mu.Unlock()
+		ln.Close()
+		if n.closed {
+			return ErrClosed
+		}
+		return errors.New("p2p: already listening")
+	}
+	n.ln = ln
+	n.mu.Unlock()
+	n.wg.Add(1)
+	go n.accept(ln)
+	return nil
+}
+
+// Addr returns the address the node listens on, or "" if it does not.
+func (n *Node) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ln == nil {
+		return ""
+	}
+	return n.ln.Addr().String()
+}
+
+func (n *Node) accept(ln net.Listener) {
+	defer n.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			if _, err := n.open(conn, true); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Connect dials a peer and completes the handshake.
+func (n *Node) Connect(addr string) (*Peer, error) {
+	if n.IsBanned(addr) {
+		return nil, fmt.Errorf("%w: %s", ErrBanned, addr)
+	}
+	conn, err := net.DialTimeout("tcp", addr, n.cfg.HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p, err := n.open(conn, false)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect %s: %w", addr, err)
+	}
+	return p, nil
+}
+
+// open handshakes on conn, registers the peer and starts serving it.
+func (n *Node) open(conn net.Conn, inbound bool) (*Peer, error) {
+	addr := conn.RemoteAddr().String()
+	if n.IsBanned(addr) {
+		return nil, ErrBanned
+	}
+	theirs, err := n.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	p := &Peer{
+		node:    n,
+		conn:    conn,
+		addr:    addr,
+		inbound: inbound,
+		version: min(theirs.Version, ProtocolVersion),
+		nonce:   theirs.Nonce,
+		send:    make(chan Message, n.cfg.SendQueue),
+		quit:    make(chan struct{}),
+		known:   make(map[InvItem]struct{}),
+	}
+
+	n.mu.Lock()
+	switch {
+	case n.closed:
+		err = ErrClosed
+	case len(n.peers) >= n.cfg.MaxPeers:
+		err = ErrTooManyPeers
+	default:
+		for q := range n.peers {
+			if q.nonce == p.nonce {
+				err = ErrDuplicatePeer
+			}
+		}
+	}
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	n.peers[p] = struct{}{}
+	n.wg.Add(2)
+	n.mu.Unlock()
+	go p.readLoop()
+	go p.writeLoop()
+
+	// Announce our tips; the peer asks for what it lacks and walks back
+	// through the parents of whatever it cannot connect.
+	var inv []InvItem
+	for _, tip := range n.dag.Tips() {
+		inv = append(inv, InvItem{Kind: InvBlock, ID: tip.Block.ID})
+	}
+	p.announce(inv)
+	n.sync.start(p)
+	return p, nil
+}
+
+// handshake swaps Version and VerAck messages with the other side and
+// returns its Version.
+func (n *Node) handshake(conn net.Conn) (*Version, error) {
+	conn.SetDeadline(time.Now().Add(n.cfg.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	genesis := n.dag.Genesis().Block.ID
+	if err := WriteMessage(conn, &Version{Version: ProtocolVersion, Genesis: genesis, Nonce: n.nonce}); err != nil {
+		return nil, err
+	}
+	m, err := ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	theirs, ok := m.(*Version)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: %T", ErrHandshake, m)
+	case theirs.Version < MinProtocolVersion:
+		return nil, fmt.Errorf("%w: %d", ErrOldVersion, theirs.Version)
+	case theirs.Genesis != genesis:
+		return nil, fmt.Errorf("%w: %s", ErrGenesisMismatch, theirs.Genesis)
+	case theirs.Nonce == n.nonce:
+		return nil, ErrSelfConnect
+	}
+	if err := WriteMessage(conn, &VerAck{}); err != nil {
+		return nil, err
+	}
+	if m, err = ReadMessage(conn); err != nil {
+		return nil, err
+	}
+	if _, ok := m.(*VerAck); !ok {
+		return nil, fmt.Errorf("%w: %T", ErrHandshake, m)
+	}
+	return theirs, nil
+}
+
+// Peers returns the connected peers, sorted by address.
+func (n *Node) Peers() []*Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peers := make([]*Peer, 0, len(n.peers))
+	for p := range n.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].addr < peers[j].addr })
+	return peers
+}
+
+// IsBanned reports whether addr is banned.
+func (n *Node) IsBanned(addr string) bool {
+	key := n.banKey(addr)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	until, ok := n.banned[key]
+	if ok && time.Now().After(until) {
+		delete(n.banned, key)
+		return false
+	}
+	return ok
+}
+
+// banKey is the host of addr, or all of addr for a loopback or private
+// host unless Config.BanLocalHosts is set: nodes sharing a machine or a
+// LAN behind one address are banned one by one.
+func (n *Node) banKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); !n.cfg.BanLocalHosts && ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+		return addr
+	}
+	return host
+}
+
+// SubmitBlock adds a block built locally, or received outside p2p, through
+// the node's orphan pool. It is relayed once it connects.
+func (n *Node) SubmitBlock(blk *block.Block) error {
+	_, _, err := n.orphans.Add(blk)
+	return err
+}
+
+// SubmitTx admits tx to the mempool and announces it.
+func (n *Node) SubmitTx(tx block.TX) error {
+	if n.mempool == nil {
+		return errors.New("p2p: node has no mempool")
+	}
+	if err := n.mempool.Add(tx); err != nil {
+		return err
+	}
+	n.announce(InvItem{Kind: InvTx, ID: tx.ID})
+	return nil
+}
+
+// announce sends an Inv for item to every peer not known to have it.
+func (n *Node) announce(item InvItem) {
+	for _, p := range n.Peers() {
+		p.announce([]InvItem{item})
+	}
+}
+
+// relay announces blocks as they are added and moves the mempool along
+// with the heaviest tip.
+func (n *Node) relay() {
+	defer n.wg.Done()
+	for ev := range n.sub.C {
+		switch ev.Kind {
+		case dag.EventBlockAdded:
+			n.announce(InvItem{Kind: InvBlock, ID: ev.Block.Block.ID})
+		case dag.EventTipChanged, dag.EventReorg:
+			if n.mempool == nil || ev.Block.UTXO == nil {
+				continue
+			}
+			n.mempool.Update(ev.Block.UTXO)
+			if ev.Reorg != nil {
+				blocks := make([]*block.Block, len(ev.Reorg.Disconnected))
+				for i, d := range ev.Reorg.Disconnected {
+					blocks[i] = d.Block
+				}
+				n.mempool.Readmit(blocks)
+			}
+		}
+	}
+}
+
+// retry re-requests sync data that timed out.
+func (n *Node) retry() {
+	defer n.wg.Done()
+	t := time.NewTicker(n.cfg.RequestTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			n.sync.tick(n.cfg.RequestTimeout)
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// SyncStatus reports the progress of syncing with peers.
+func (n *Node) SyncStatus() SyncStatus {
+	return n.sync.Status()
+}
+
+// have reports whether the node has item or has already asked a peer for
+// it recently. Otherwise the item is recorded as requested from p.
+func (n *Node) have(item InvItem, p *Peer) bool {
+	switch item.Kind {
+	case InvBlock:
+		if n.dag.Node(item.ID) != nil || n.orphans.Has(item.ID) || n.sync.queued(item.ID) {
+			return true
+		}
+	case InvTx:
+		if n.mempool == nil {
+			return true
+		}
+		if _, ok := n.mempool.Get(item.ID); ok {
+			return true
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if r, ok := n.inflight[item]; ok && now.Sub(r.at) < n.cfg.RequestTimeout {
+		return true
+	}
+	n.inflight[item] = request{peer: p, at: now}
+	return false
+}
+
+// received clears a request for item.
+func (n *Node) received(item InvItem) (requested bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, requested = n.inflight[item]
+	delete(n.inflight, item)
+	return requested
+}
+
+// drop unregisters p and forgets what was asked of it, so other peers can
+// be asked instead. The syncer is told on another goroutine, as a peer may
+// be dropped while the syncer is adding a block and waiting on the relay.
+func (n *Node) drop(p *Peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.peers, p)
+	for item, r := range n.inflight {
+		if r.peer == p {
+			delete(n.inflight, item)
+		}
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.sync.dropped(p)
+	}()
+}
+
+// ban keeps addr, or its host, from connecting for BanDuration.
+func (n *Node) ban(addr string) {
+	key := n.banKey(addr)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.banned[key] = time.Now().Add(n.cfg.BanDuration)
+}
+
+// Close disconnects every peer, stops listening and waits for the node's
+// goroutines to finish.
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.quit)
+	var err error
+	if n.ln != nil {
+		err = n.ln.Close()
+	}
+	peers := make([]*Peer, 0, len(n.peers))
+	for p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.mu.Unlock()
+
+	for _, p := range peers {
+		p.close(ErrClosed)
+	}
+	n.sub.Unsubscribe()
+	n.wg.Wait()
+	return err
+}
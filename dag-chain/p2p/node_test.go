@@ -0,0 +1,413 @@
+package p2p_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+	"github.com/Abdullah-zahoor/dagchain/miner"
+	"github.com/Abdullah-zahoor/dagchain/p2p"
+)
+
+var (
+	ts    = time.Unix(1700000000, 0)
+	seed  = sha256.Sum256([]byte("alice"))
+	alice = block.KeyFromSeed(seed[:])
+	coin  = block.UTXOKey{TxID: "coin"}
+)
+
+type testNode struct {
+	*p2p.Node
+	dag  *dag.DAG
+	pool *mempool.Pool
+}
+
+// newNode starts a node listening on loopback with its own DAG on the
+// shared genesis. genesisTime tells networks apart.
+func newNode(t *testing.T, genesisTime time.Time) *testNode {
+	t.Helper()
+	return newNodeConfig(t, genesisTime, p2p.Config{HandshakeTimeout: time.Second})
+}
+
+func newNodeConfig(t *testing.T, genesisTime time.Time, cfg p2p.Config) *testNode {
+	t.Helper()
+	d := dag.NewDAG()
+	d.Params = dag.DefaultParams()
+	state := block.NewUTXOTrie()
+	state.Put(coin, block.TXOutput{Value: 100, Recipient: alice.Address()})
+	if err := d.AddGenesis(block.NewBlock(nil, nil, genesisTime), state); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := mempool.New(d.Genesis().UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := p2p.New(d, pool, cfg)
+	if err := n.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return &testNode{Node: n, dag: d, pool: pool}
+}
+
+// mine builds count blocks on n's heaviest tip with txs from its mempool.
+func (n *testNode) mine(t *testing.T, count int) {
+	t.Helper()
+	b := miner.New(n.dag, miner.Config{Source: n.pool, Payout: "miner"})
+	for i := 0; i < count; i++ {
+		blk, err := b.Build(ts.Add(time.Duration(n.dag.Len())*time.Second), []byte(n.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.SubmitBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGossip(t *testing.T) {
+	a, b, c := newNode(t, ts), newNode(t, ts), newNode(t, ts)
+	if _, err := a.Connect(b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	a.mine(t, 3)
+	waitFor(t, "b to get a's blocks", func() bool { return b.dag.Len() == 4 })
+
+	// c joins late and walks back from b's tip through the orphan pool
+	if _, err := c.Connect(b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	tip := a.dag.HeaviestTip().Block.ID
+	waitFor(t, "c to catch up", func() bool { return c.dag.HeaviestTip().Block.ID == tip })
+
+	// a transaction submitted at c reaches a's mempool through b, and a
+	// block confirming it clears every mempool
+	tx := block.NewTX([]block.TXInput{{PrevTxID: coin.TxID}},
+		[]block.TXOutput{{Value: 90, Recipient: alice.Address()}}, nil)
+	tx.Sign(0, alice)
+	if err := c.SubmitTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to get the tx", func() bool { _, ok := a.pool.Get(tx.ID); return ok })
+	a.mine(t, 1)
+	waitFor(t, "the tx to be confirmed everywhere", func() bool {
+		return c.dag.Len() == 5 && c.pool.Len() == 0 && b.pool.Len() == 0
+	})
+	if len(b.Peers()) != 2 {
+		t.Errorf("b has %d peers, want 2", len(b.Peers()))
+	}
+	for _, p := range b.Peers() {
+		if p.Score() != 0 {
+			t.Errorf("peer %s scored %d for honest gossip", p.Addr(), p.Score())
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	a, b := newNode(t, ts), newNode(t, ts)
+	other := newNode(t, ts.Add(time.Hour))
+
+	if _, err := a.Connect(other.Addr()); !errors.Is(err, p2p.ErrGenesisMismatch) {
+		t.Errorf("other network: got %v, want ErrGenesisMismatch", err)
+	}
+	if _, err := a.Connect(a.Addr()); !errors.Is(err, p2p.ErrSelfConnect) {
+		t.Errorf("self: got %v, want ErrSelfConnect", err)
+	}
+	p, err := a.Connect(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version() != p2p.ProtocolVersion || p.Inbound() {
+		t.Errorf("peer: version %d, inbound %v", p.Version(), p.Inbound())
+	}
+	if _, err := a.Connect(b.Addr()); !errors.Is(err, p2p.ErrDuplicatePeer) {
+		t.Errorf("second connection: got %v, want ErrDuplicatePeer", err)
+	}
+	if len(a.Peers()) != 1 {
+		t.Errorf("a has %d peers, want 1", len(a.Peers()))
+	}
+
+	// an old version is refused
+	conn, err := net.Dial("tcp", b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p2p.WriteMessage(conn, &p2p.Version{Version: 0, Genesis: b.dag.Genesis().Block.ID, Nonce: 1})
+	for {
+		m, err := p2p.ReadMessage(conn)
+		if err != nil {
+			break
+		}
+		if _, ok := m.(*p2p.VerAck); ok {
+			t.Fatal("old version acknowledged")
+		}
+	}
+}
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	testBan(t, false)
+	testBan(t, true)
+}
+
+// testBan bans a loopback peer. Other loopback peers are cut off only
+// when banning by host.
+func testBan(t *testing.T, byHost bool) {
+	n := newNodeConfig(t, ts, p2p.Config{HandshakeTimeout: time.Second, BanLocalHosts: byHost})
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", n.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	conn := dial()
+	p2p.WriteMessage(conn, &p2p.Version{Version: p2p.ProtocolVersion, Genesis: n.dag.Genesis().Block.ID, Nonce: 1})
+	p2p.WriteMessage(conn, &p2p.VerAck{})
+	waitFor(t, "the handshake", func() bool { return len(n.Peers()) == 1 })
+
+	// a coinbase minting far more than the subsidy
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1 << 60, Recipient: "thief"}}, nil)
+	bad := block.NewBlock([]string{n.dag.Genesis().Block.ID}, []block.TX{cb}, ts.Add(time.Second))
+	if err := p2p.WriteMessage(conn, &p2p.BlockMsg{Block: bad}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := p2p.ReadMessage(conn); err != nil {
+			break // disconnected
+		}
+	}
+	waitFor(t, "the peer to be dropped", func() bool { return len(n.Peers()) == 0 })
+	if !n.IsBanned(conn.LocalAddr().String()) || n.dag.Node(bad.ID) != nil {
+		t.Fatal("peer sending an invalid block was not banned")
+	}
+
+	conn = dial()
+	p2p.WriteMessage(conn, &p2p.Version{Version: p2p.ProtocolVersion, Genesis: n.dag.Genesis().Block.ID, Nonce: 2})
+	m, err := p2p.ReadMessage(conn)
+	if byHost && err == nil {
+		// the banned host is cut off before the handshake
+		t.Fatalf("banned host got %T", m)
+	}
+	if !byHost && err != nil {
+		t.Fatalf("another peer on the banned peer's host: %v", err)
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	msgs := []p2p.Message{
+		&p2p.Inv{Items: []p2p.InvItem{{Kind: p2p.InvBlock, ID: "b1"}, {Kind: p2p.InvTx, ID: "t1"}}},
+		&p2p.GetData{Items: []p2p.InvItem{{Kind: p2p.InvTx, ID: "t1"}}},
+		&p2p.NotFound{},
+	}
+	go func() {
+		for _, m := range msgs {
+			p2p.WriteMessage(a, m)
+		}
+		a.Write([]byte{0, 0, 0, 1, 99, 0}) // unknown command
+	}()
+	for _, want := range msgs {
+		got, err := p2p.ReadMessage(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	if _, err := p2p.ReadMessage(b); !errors.Is(err, p2p.ErrMalformed) {
+		t.Errorf("unknown command: got %v, want ErrMalformed", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	a := newNode(t, ts)
+	// more blocks than fit in one Headers message, with a merged side block
+	a.mine(t, p2p.MaxHeaders+50)
+	side := miner.New(a.dag, miner.Config{Payout: "side"})
+	blk, err := side.Build(ts.Add(time.Hour), []byte("side"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk2, err := side.Build(ts.Add(time.Hour), []byte("side2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*block.Block{blk, blk2} {
+		if err := a.SubmitBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.mine(t, 3)
+	want := a.dag.Len()
+
+	b, c := newNode(t, ts), newNode(t, ts)
+	for _, n := range []*testNode{b, c} {
+		if _, err := n.Connect(a.Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "b and c to sync from a", func() bool { return b.dag.Len() == want && c.dag.Len() == want })
+
+	// d syncs from a and fetches bodies from all three
+	d := newNode(t, ts)
+	for _, n := range []*testNode{a, b, c} {
+		if _, err := d.Connect(n.Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "d to sync", func() bool { return d.SyncStatus().State == p2p.SyncIdle && d.dag.Len() == want })
+	st := d.SyncStatus()
+	if st.Err != "" || st.Total != want-1 || st.Connected != st.Headers || st.Finished.IsZero() {
+		t.Errorf("sync status %+v, want %d blocks connected without error", st, want-1)
+	}
+	if d.dag.HeaviestTip().Block.ID != a.dag.HeaviestTip().Block.ID {
+		t.Error("d's heaviest tip differs from a's")
+	}
+	for _, p := range d.Peers() {
+		if p.Score() != 0 {
+			t.Errorf("peer %s scored %d for honest sync", p.Addr(), p.Score())
+		}
+	}
+}
+
+func TestSyncInRounds(t *testing.T) {
+	a := newNode(t, ts)
+	a.mine(t, p2p.MaxHeaders+20)
+	want := a.dag.Len()
+
+	// one page of headers per round
+	b := newNodeConfig(t, ts, p2p.Config{HandshakeTimeout: time.Second, MaxSyncHeaders: 1})
+	if _, err := b.Connect(a.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "b to sync", func() bool { return b.SyncStatus().State == p2p.SyncIdle && b.dag.Len() == want })
+	st := b.SyncStatus()
+	if st.Err != "" || st.Total != want-1 || st.Headers >= want-1 {
+		t.Errorf("sync status %+v, want %d blocks over more than one round", st, want-1)
+	}
+}
+
+func TestGetHeadersUnknownAfter(t *testing.T) {
+	n := newNode(t, ts)
+	n.mine(t, 3)
+	conn := rawPeer(t, n, 1)
+	// continuing an answer that was never started
+	if err := p2p.WriteMessage(conn, &p2p.GetHeaders{Locator: []string{n.dag.Genesis().Block.ID}, After: "made-up"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the peer to be scored", func() bool {
+		peers := n.Peers()
+		return len(peers) == 1 && peers[0].Score() > 0
+	})
+
+	if err := p2p.WriteMessage(conn, &p2p.GetHeaders{Locator: []string{n.dag.Genesis().Block.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		m, err := p2p.ReadMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h, ok := m.(*p2p.Headers); ok {
+			if len(h.Headers) != 3 || h.More {
+				t.Errorf("got %d headers, more %v; want 3", len(h.Headers), h.More)
+			}
+			break
+		}
+	}
+}
+
+// rawPeer completes the handshake with n over a bare connection.
+func rawPeer(t *testing.T, n *testNode, nonce uint64) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", n.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	p2p.WriteMessage(conn, &p2p.Version{Version: p2p.ProtocolVersion, Genesis: n.dag.Genesis().Block.ID, Nonce: nonce})
+	p2p.WriteMessage(conn, &p2p.VerAck{})
+	waitFor(t, "the handshake", func() bool { return len(n.Peers()) == 1 })
+	return conn
+}
+
+func TestUnsolicitedBlock(t *testing.T) {
+	n := newNode(t, ts)
+	conn := rawPeer(t, n, 1)
+
+	cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: "x"}}, nil)
+	orphan := block.NewBlock([]string{"made-up"}, []block.TX{cb}, ts.Add(time.Second))
+	if err := p2p.WriteMessage(conn, &p2p.BlockMsg{Block: orphan}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the peer to be scored", func() bool {
+		peers := n.Peers()
+		return len(peers) == 1 && peers[0].Score() > 0
+	})
+
+	// the block was not kept: announcing it gets it requested
+	p2p.WriteMessage(conn, &p2p.Inv{Items: []p2p.InvItem{{Kind: p2p.InvBlock, ID: orphan.ID}}})
+	for {
+		m, err := p2p.ReadMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gd, ok := m.(*p2p.GetData); ok && gd.Items[0].ID == orphan.ID {
+			break
+		}
+	}
+}
+
+func TestLargeGetData(t *testing.T) {
+	n := newNode(t, ts)
+	n.mine(t, 600)
+	var items []p2p.InvItem
+	for id := range n.dag.View().Nodes() {
+		items = append(items, p2p.InvItem{Kind: p2p.InvBlock, ID: id})
+	}
+
+	// more items than the send queue holds, asked for at once
+	conn := rawPeer(t, n, 1)
+	if err := p2p.WriteMessage(conn, &p2p.GetData{Items: items}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for got := 0; got < len(items); {
+		m, err := p2p.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("after %d blocks: %v", got, err)
+		}
+		if _, ok := m.(*p2p.BlockMsg); ok {
+			got++
+		}
+	}
+	if len(n.Peers()) != 1 {
+		t.Error("peer asking for many blocks was dropped")
+	}
+}
@@ -0,0 +1,328 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/mempool"
+)
+
+// Misbehavior points. A peer reaching Config.BanScore is banned, so the
+// scores are relative to its default of 100.
+const (
+	scoreMalformed     = 100 // a message that does not decode
+	scoreInvalidBlock  = 100 // a block breaking a consensus rule
+	scoreInvalidTx     = 10  // a transaction that can never be valid
+	scoreProtocolError = 10  // a message out of place, such as a second Version
+	scoreUnsolicited   = 10  // a block we lack and never asked for
+)
+
+// maxKnown bounds the inventory remembered per peer. Past it the set
+// starts over, which only costs some redundant announcements.
+const maxKnown = 50000
+
+// Peer is a connection to another node that completed the handshake.
+type Peer struct {
+	node    *Node
+	conn    net.Conn
+	addr    string
+	inbound bool
+	version uint32
+	nonce   uint64
+	send    chan Message
+	quit    chan struct{}
+	once    sync.Once
+	headers *dag.AntiPastWalk // the GetHeaders answer in progress; read loop only
+
+	mu    sync.Mutex
+	score int
+	known map[InvItem]struct{} // items the peer has or was told of
+	err   error                // why the peer was closed
+}
+
+// Addr returns the peer's remote address.
+func (p *Peer) Addr() string { return p.addr }
+
+// Inbound reports whether the peer connected to us.
+func (p *Peer) Inbound() bool { return p.inbound }
+
+// Version returns the protocol version in use with the peer.
+func (p *Peer) Version() uint32 { return p.version }
+
+// Score returns the peer's misbehavior points.
+func (p *Peer) Score() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.score
+}
+
+// Done is closed once the peer is disconnected.
+func (p *Peer) Done() <-chan struct{} { return p.quit }
+
+// Err returns why the peer was disconnected, or nil while it is connected.
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close disconnects the peer.
+func (p *Peer) Close() { p.close(ErrClosed) }
+
+func (p *Peer) close(err error) {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		close(p.quit)
+		p.conn.Close()
+		p.node.drop(p)
+	})
+}
+
+// queue hands m to the writer. A peer whose queue is full is dropped
+// rather than letting it stall the node.
+func (p *Peer) queue(m Message) {
+	select {
+	case p.send <- m:
+	case <-p.quit:
+	default:
+		p.close(ErrSlowPeer)
+	}
+}
+
+// reply hands m, an answer to the peer's own request, to the writer. It
+// waits up to SendTimeout for room rather than dropping the peer, so a
+// peer asking for more items than the queue holds is slowed down, and one
+// that stops reading is dropped once the wait runs out. It runs on the
+// peer's read loop, which stops reading requests meanwhile.
+func (p *Peer) reply(m Message) {
+	select {
+	case p.send <- m:
+		return
+	case <-p.quit:
+		return
+	default:
+	}
+	t := time.NewTimer(p.node.cfg.SendTimeout)
+	defer t.Stop()
+	select {
+	case p.send <- m:
+	case <-p.quit:
+	case <-t.C:
+		p.close(ErrSlowPeer)
+	}
+}
+
+// announce sends an Inv for the items the peer is not known to have.
+func (p *Peer) announce(items []InvItem) {
+	var inv []InvItem
+	p.mu.Lock()
+	for _, it := range items {
+		if _, ok := p.known[it]; !ok {
+			p.markKnownLocked(it)
+			inv = append(inv, it)
+		}
+	}
+	p.mu.Unlock()
+	if len(inv) > 0 {
+		p.queue(&Inv{Items: inv})
+	}
+}
+
+func (p *Peer) markKnown(it InvItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.markKnownLocked(it)
+}
+
+func (p *Peer) markKnownLocked(it InvItem) {
+	if len(p.known) >= maxKnown {
+		clear(p.known)
+	}
+	p.known[it] = struct{}{}
+}
+
+// misbehave adds points to the peer's score, and bans and disconnects it
+// once the score reaches BanScore.
+func (p *Peer) misbehave(points int, why error) {
+	p.mu.Lock()
+	p.score += points
+	banned := p.score >= p.node.cfg.BanScore
+	p.mu.Unlock()
+	if banned {
+		p.node.ban(p.addr)
+		p.close(fmt.Errorf("%w: %v", ErrBanned, why))
+	}
+}
+
+func (p *Peer) writeLoop() {
+	defer p.node.wg.Done()
+	for {
+		select {
+		case m := <-p.send:
+			if err := WriteMessage(p.conn, m); err != nil {
+				p.close(err)
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) readLoop() {
+	defer p.node.wg.Done()
+	for {
+		m, err := ReadMessage(p.conn)
+		if err != nil {
+			if errors.Is(err, ErrMalformed) || errors.Is(err, ErrMessageSize) {
+				p.misbehave(scoreMalformed, err)
+			}
+			p.close(err)
+			return
+		}
+		p.handle(m)
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+	}
+}
+
+func (p *Peer) handle(m Message) {
+	n := p.node
+	switch m := m.(type) {
+	case *Version, *VerAck:
+		p.misbehave(scoreProtocolError, fmt.Errorf("%w: %T after handshake", ErrHandshake, m))
+
+	case *Inv:
+		var want []InvItem
+		for _, it := range m.Items {
+			p.markKnown(it)
+			if !n.have(it, p) {
+				want = append(want, it)
+			}
+		}
+		if len(want) > 0 {
+			p.queue(&GetData{Items: want})
+		}
+
+	case *GetData:
+		var missing []InvItem
+		for _, it := range m.Items {
+			var reply Message
+			switch it.Kind {
+			case InvBlock:
+				if node := n.dag.Node(it.ID); node != nil {
+					reply = &BlockMsg{Block: node.Block}
+				}
+			case InvTx:
+				if n.mempool != nil {
+					if tx, ok := n.mempool.Get(it.ID); ok {
+						reply = &TxMsg{Tx: tx}
+					}
+				}
+			}
+			if reply == nil {
+				missing = append(missing, it)
+				continue
+			}
+			p.markKnown(it)
+			p.reply(reply)
+		}
+		if len(missing) > 0 {
+			p.reply(&NotFound{Items: missing})
+		}
+
+	case *NotFound:
+		for _, it := range m.Items {
+			n.received(it)
+			n.sync.notFound(p, it)
+		}
+
+	case *GetHeaders:
+		n.serveHeaders(p, m)
+
+	case *Headers:
+		n.sync.headers(p, m)
+
+	case *BlockMsg:
+		p.handleBlock(m.Block)
+
+	case *TxMsg:
+		it := InvItem{Kind: InvTx, ID: m.Tx.ID}
+		n.received(it)
+		p.markKnown(it)
+		if n.mempool == nil {
+			return
+		}
+		err := n.mempool.Add(m.Tx)
+		switch {
+		case err == nil:
+			n.announce(it)
+		case errors.Is(err, mempool.ErrCoinbase):
+			p.misbehave(scoreInvalidTx, err)
+		case block.IsInvalid(err) && !errors.Is(err, block.ErrMissingInput):
+			// a missing input may just be a block we have not seen yet
+			p.misbehave(scoreInvalidTx, err)
+		}
+	}
+}
+
+// handleBlock passes blk to the syncer if it asked for it, and otherwise
+// connects it through the orphan pool. If its parents are missing, the
+// node syncs with the peer that sent it, or asks an older peer for them.
+// A block we lack and never asked for costs the peer points, and is only
+// added if its parents are in, so unsolicited blocks cannot fill the
+// orphan pool.
+func (p *Peer) handleBlock(blk *block.Block) {
+	n := p.node
+	it := InvItem{Kind: InvBlock, ID: blk.ID}
+	requested := n.received(it)
+	p.markKnown(it)
+	if n.sync.deliver(p, blk) {
+		return
+	}
+	if !requested {
+		if n.dag.Node(blk.ID) != nil || n.orphans.Has(blk.ID) {
+			return // a late answer to a request another peer answered
+		}
+		p.misbehave(scoreUnsolicited, fmt.Errorf("%w: %s", ErrUnsolicited, blk.ID))
+		for _, pid := range blk.Parents {
+			if n.dag.Node(pid) == nil {
+				return
+			}
+		}
+	}
+	connected, _, err := n.orphans.Add(blk)
+	if err != nil {
+		if dag.IsInvalid(err) {
+			p.misbehave(scoreInvalidBlock, err)
+		}
+		return
+	}
+	if len(connected) > 0 || !n.orphans.Has(blk.ID) {
+		return
+	}
+	if p.version >= SyncVersion {
+		n.sync.start(p)
+		return
+	}
+	var want []InvItem
+	for _, pid := range blk.Parents {
+		parent := InvItem{Kind: InvBlock, ID: pid}
+		if !n.have(parent, p) {
+			want = append(want, parent)
+		}
+	}
+	if len(want) > 0 {
+		p.queue(&GetData{Items: want})
+	}
+}
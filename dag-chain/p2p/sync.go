@@ -0,0 +1,515 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// SyncVersion is the first protocol version with GetHeaders and Headers.
+// Older peers are caught up with by walking back from orphans instead.
+const SyncVersion = 2
+
+// Body fetch limits: how far past the next block to connect bodies are
+// requested, and how many may be outstanding with one peer.
+const (
+	syncWindow  = 512
+	syncPerPeer = 64
+)
+
+var errSyncTimeout = errors.New("p2p: sync peer did not answer in time")
+
+// SyncState is what the syncer is doing.
+type SyncState int
+
+const (
+	SyncIdle    SyncState = iota // not syncing
+	SyncHeaders                  // downloading headers; bodies may be on the way too
+	SyncBlocks                   // all headers in, fetching and connecting bodies
+)
+
+func (s SyncState) String() string {
+	switch s {
+	case SyncIdle:
+		return "idle"
+	case SyncHeaders:
+		return "headers"
+	case SyncBlocks:
+		return "blocks"
+	}
+	return "SyncState(?)"
+}
+
+func (s SyncState) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *SyncState) UnmarshalText(b []byte) error {
+	for _, st := range []SyncState{SyncIdle, SyncHeaders, SyncBlocks} {
+		if st.String() == string(b) {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("p2p: unknown sync state %q", b)
+}
+
+// SyncStatus reports the progress of the current or last sync round.
+type SyncStatus struct {
+	State     SyncState
+	Peer      string    // the headers source, while syncing
+	Headers   int       // headers of blocks we lacked, this round
+	Connected int       // of those, blocks now in the DAG, in order
+	InFlight  int       // bodies requested and not yet received
+	Total     int       // blocks synced over every round
+	Started   time.Time // start of this or the last round
+	Finished  time.Time // end of the last round; zero before the first
+	Err       string    // why the last round stopped early, if it did
+}
+
+// body tracks one block of a sync round.
+type body struct {
+	peer   *Peer     // asked for it, until it arrives
+	at     time.Time // when it was asked for
+	avoid  *Peer     // said it does not have it
+	blk    *block.Block
+	sender *Peer
+}
+
+// outgoing is a message or penalty for a peer. The syncer holds its lock
+// while deciding them and hands them out after releasing it, as closing a
+// peer calls back into the syncer.
+type outgoing struct {
+	peer   *Peer
+	msg    Message
+	points int
+	why    error
+}
+
+// syncer downloads the blocks of the missing anti-past from one peer at a
+// time: headers first from that peer, against a locator of our selected
+// chain, then bodies in parallel from every peer speaking SyncVersion.
+// Blocks are connected in header order, which is topological.
+type syncer struct {
+	n *Node
+
+	mu         sync.Mutex
+	connecting bool // a goroutine is in connect
+	state      SyncState
+	source     *Peer
+	locator    []string
+	asked      time.Time // when the last GetHeaders went out
+	order      []string  // header IDs in the order received
+	bodies     map[string]*body
+	next       int // order[:next] are in the DAG
+	load       map[*Peer]int
+	pending    map[*Peer]bool // peers to sync from once this round ends
+	started    time.Time
+	finished   time.Time
+	err        error
+	total      int
+}
+
+func newSyncer(n *Node) *syncer {
+	return &syncer{n: n, pending: make(map[*Peer]bool)}
+}
+
+func (s *syncer) send(out []outgoing) {
+	for _, o := range out {
+		if o.msg != nil {
+			o.peer.queue(o.msg)
+		}
+		if o.points > 0 {
+			o.peer.misbehave(o.points, o.why)
+		}
+	}
+}
+
+// Status returns a snapshot of the syncer's progress.
+func (s *syncer) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SyncStatus{
+		State:     s.state,
+		Headers:   len(s.order),
+		Connected: s.next,
+		Total:     s.total,
+		Started:   s.started,
+		Finished:  s.finished,
+	}
+	if s.source != nil {
+		st.Peer = s.source.addr
+	}
+	for _, n := range s.load {
+		st.InFlight += n
+	}
+	if s.err != nil {
+		st.Err = s.err.Error()
+	}
+	return st
+}
+
+// start syncs from p, or queues p for after the current round.
+func (s *syncer) start(p *Peer) {
+	if p.version < SyncVersion {
+		return
+	}
+	s.mu.Lock()
+	out := s.startLocked(p)
+	s.mu.Unlock()
+	s.send(out)
+}
+
+func (s *syncer) startLocked(p *Peer) []outgoing {
+	if s.state != SyncIdle {
+		if p != s.source {
+			s.pending[p] = true
+		}
+		return nil
+	}
+	delete(s.pending, p)
+	s.state = SyncHeaders
+	s.source = p
+	s.locator = s.n.dag.Locator()
+	s.asked = time.Now()
+	s.order = nil
+	s.bodies = make(map[string]*body)
+	s.next = 0
+	s.load = make(map[*Peer]int)
+	s.started = s.asked
+	s.err = nil
+	return []outgoing{{peer: p, msg: &GetHeaders{Locator: s.locator}}}
+}
+
+// finishLocked ends the round and starts the next pending one, if any.
+func (s *syncer) finishLocked(err error) []outgoing {
+	s.state = SyncIdle
+	s.source = nil
+	s.bodies = nil
+	s.load = nil
+	s.finished = time.Now()
+	s.err = err
+	for p := range s.pending {
+		delete(s.pending, p)
+		select {
+		case <-p.quit:
+			continue
+		default:
+		}
+		return s.startLocked(p)
+	}
+	return nil
+}
+
+// queued reports whether the block is part of the current round.
+func (s *syncer) queued(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bodies[id]
+	return ok
+}
+
+// headers takes a page of headers from the round's source. Each must
+// build on blocks in the DAG or on headers before it.
+func (s *syncer) headers(p *Peer, m *Headers) {
+	s.mu.Lock()
+	out := s.headersLocked(p, m)
+	s.mu.Unlock()
+	s.send(out)
+}
+
+func (s *syncer) headersLocked(p *Peer, m *Headers) []outgoing {
+	if p != s.source || s.state != SyncHeaders {
+		return nil // unsolicited or late
+	}
+	if len(m.Headers) > MaxHeaders {
+		err := fmt.Errorf("%w: %d headers", ErrMalformed, len(m.Headers))
+		return append(s.finishLocked(err), outgoing{peer: p, points: scoreMalformed, why: err})
+	}
+	d := s.n.dag
+	var last string
+	for i := range m.Headers {
+		h := &m.Headers[i]
+		id := h.Hash()
+		last = id
+		p.markKnown(InvItem{Kind: InvBlock, ID: id})
+		if _, ok := s.bodies[id]; ok || d.Node(id) != nil {
+			continue
+		}
+		if err := s.checkHeader(id, h); err != nil {
+			points := scoreInvalidBlock
+			if errors.Is(err, dag.ErrMissingParent) {
+				// possibly a parent we pruned
+				points = scoreProtocolError
+			}
+			return append(s.finishLocked(err), outgoing{peer: p, points: points, why: err})
+		}
+		s.order = append(s.order, id)
+		s.bodies[id] = &body{}
+	}
+	var out []outgoing
+	switch {
+	case !m.More || last == "":
+		s.state = SyncBlocks
+	case len(s.order) >= s.n.cfg.MaxSyncHeaders:
+		// fetch what we have and go on in a new round from there
+		s.pending[p] = true
+		s.state = SyncBlocks
+	default:
+		s.asked = time.Now()
+		out = append(out, outgoing{peer: p, msg: &GetHeaders{Locator: s.locator, After: last}})
+	}
+	return append(out, s.progressLocked()...)
+}
+
+// checkHeader checks what can be checked without the body: that the
+// parents are known and, under proof of work, that the hash meets the
+// difficulty the header claims.
+func (s *syncer) checkHeader(id string, h *block.Header) error {
+	if len(h.Parents) == 0 {
+		return &dag.RuleError{BlockID: id, Err: dag.ErrNoParents}
+	}
+	for _, pid := range h.Parents {
+		if _, ok := s.bodies[pid]; !ok && s.n.dag.Node(pid) == nil {
+			return fmt.Errorf("header %s: %w: %s", id, dag.ErrMissingParent, pid)
+		}
+	}
+	if s.n.dag.Params.ProofOfWork && !(&block.Block{Header: *h, ID: id}).MeetsTarget() {
+		return &dag.RuleError{BlockID: id, Err: dag.ErrInsufficientWork}
+	}
+	return nil
+}
+
+// deliver takes a block the round asked for and reports whether it did.
+// It connects what it can before returning.
+func (s *syncer) deliver(p *Peer, blk *block.Block) bool {
+	s.mu.Lock()
+	b, ok := s.bodies[blk.ID]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	var out []outgoing
+	if b.blk == nil {
+		if err := errors.Join(blk.CheckTxRoot(), blk.CheckSignature()); err != nil {
+			out = append(out, outgoing{peer: p, points: scoreInvalidBlock, why: err})
+			s.unassign(b)
+			b.avoid = p
+		} else {
+			s.unassign(b)
+			b.blk, b.sender = blk, p
+		}
+		out = append(out, s.progressLocked()...)
+	}
+	s.mu.Unlock()
+	s.send(out)
+	s.connect()
+	return true
+}
+
+func (s *syncer) unassign(b *body) {
+	if b.peer != nil {
+		s.load[b.peer]--
+		b.peer = nil
+	}
+}
+
+// notFound handles a peer lacking a body it was asked for. Another peer is
+// asked instead, unless it was the source, which announced the header.
+func (s *syncer) notFound(p *Peer, it InvItem) {
+	if it.Kind != InvBlock {
+		return
+	}
+	s.mu.Lock()
+	var out []outgoing
+	if b, ok := s.bodies[it.ID]; ok && b.peer == p {
+		s.unassign(b)
+		if p == s.source {
+			out = s.finishLocked(fmt.Errorf("p2p: sync peer lacks block %s it announced", it.ID))
+		} else {
+			b.avoid = p
+			out = s.progressLocked()
+		}
+	}
+	s.mu.Unlock()
+	s.send(out)
+}
+
+// dropped forgets a disconnected peer. Losing the source before all
+// headers are in ends the round.
+func (s *syncer) dropped(p *Peer) {
+	s.mu.Lock()
+	delete(s.pending, p)
+	var out []outgoing
+	if s.state != SyncIdle {
+		for _, b := range s.bodies {
+			if b.peer == p {
+				s.unassign(b)
+			}
+		}
+		delete(s.load, p)
+		if p == s.source && s.state == SyncHeaders {
+			out = s.finishLocked(p.Err())
+		} else {
+			out = s.progressLocked()
+		}
+	}
+	s.mu.Unlock()
+	s.send(out)
+}
+
+// tick gives up on requests older than timeout and asks again.
+func (s *syncer) tick(timeout time.Duration) {
+	s.mu.Lock()
+	var out []outgoing
+	now := time.Now()
+	if s.state == SyncHeaders && now.Sub(s.asked) > timeout {
+		out = s.finishLocked(errSyncTimeout)
+	} else if s.state != SyncIdle {
+		for _, b := range s.bodies {
+			if b.peer != nil && now.Sub(b.at) > timeout {
+				b.avoid = b.peer
+				s.unassign(b)
+			}
+		}
+		out = s.progressLocked()
+	}
+	s.mu.Unlock()
+	s.send(out)
+}
+
+// progressLocked moves past the blocks next in order that are in the DAG,
+// ends the round once every block is in, and otherwise requests more
+// bodies. connect adds the bodies.
+func (s *syncer) progressLocked() []outgoing {
+	if s.state == SyncIdle {
+		return nil
+	}
+	for s.next < len(s.order) && s.n.dag.Node(s.order[s.next]) != nil {
+		delete(s.bodies, s.order[s.next])
+		s.next++
+		s.total++
+	}
+	if s.state == SyncBlocks && s.next == len(s.order) {
+		return s.finishLocked(nil)
+	}
+	return s.fillLocked()
+}
+
+// connect adds the bodies that are next in order to the DAG. s.mu is
+// released around each add, as validation takes a while and Status and
+// deliveries should not wait on it; connecting keeps a second caller from
+// adding out of order meanwhile.
+func (s *syncer) connect() {
+	s.mu.Lock()
+	if s.connecting {
+		s.mu.Unlock()
+		return
+	}
+	s.connecting = true
+	var out []outgoing
+	for {
+		out = append(out, s.progressLocked()...)
+		if s.state == SyncIdle || s.next == len(s.order) {
+			break
+		}
+		id := s.order[s.next]
+		b := s.bodies[id]
+		if b.blk == nil {
+			break
+		}
+		s.mu.Unlock()
+		s.send(out)
+		out = nil
+		// through the orphan pool, so orphans waiting on it connect
+		_, _, err := s.n.orphans.Add(b.blk)
+		s.mu.Lock()
+		if s.bodies[id] != b {
+			continue // the round ended, or progressLocked moved past it
+		}
+		if err != nil && s.n.dag.Node(id) == nil {
+			if dag.IsInvalid(err) {
+				out = append(out, outgoing{peer: b.sender, points: scoreInvalidBlock, why: err})
+			}
+			out = append(out, s.finishLocked(err)...)
+			continue
+		}
+		delete(s.bodies, id)
+		s.next++
+		s.total++
+	}
+	s.connecting = false
+	s.mu.Unlock()
+	s.send(out)
+}
+
+// fillLocked requests the bodies in the window past next that nobody is
+// fetching, spreading them over the least loaded peers.
+func (s *syncer) fillLocked() []outgoing {
+	var peers []*Peer
+	for _, p := range s.n.Peers() {
+		if p.version >= SyncVersion {
+			peers = append(peers, p)
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+	batches := make(map[*Peer][]InvItem)
+	now := time.Now()
+	for i := s.next; i < len(s.order) && i < s.next+syncWindow; i++ {
+		id := s.order[i]
+		b := s.bodies[id]
+		if b.blk != nil || b.peer != nil || s.n.dag.Node(id) != nil {
+			continue
+		}
+		var best *Peer
+		for _, p := range peers {
+			if (p == b.avoid && len(peers) > 1) || s.load[p] >= syncPerPeer {
+				continue
+			}
+			if best == nil || s.load[p] < s.load[best] {
+				best = p
+			}
+		}
+		if best == nil {
+			break
+		}
+		b.peer, b.at = best, now
+		s.load[best]++
+		batches[best] = append(batches[best], InvItem{Kind: InvBlock, ID: id})
+	}
+	var out []outgoing
+	for _, p := range peers {
+		if items := batches[p]; len(items) > 0 {
+			out = append(out, outgoing{peer: p, msg: &GetData{Items: items}})
+		}
+	}
+	return out
+}
+
+// serveHeaders answers a GetHeaders from our DAG. A first request starts
+// a walk over the anti-past of the fork point in a View; a request with
+// After continues it from where the last page ended, so a long answer is
+// walked once. An After other than the last header sent is refused. It
+// runs on p's read loop, which owns p.headers.
+func (n *Node) serveHeaders(p *Peer, m *GetHeaders) {
+	if m.After == "" {
+		v := n.dag.View()
+		p.headers = v.WalkAntiPast(v.FindFork(m.Locator))
+	} else if p.headers == nil || p.headers.Last() == nil || p.headers.Last().Block.ID != m.After {
+		p.headers = nil
+		p.misbehave(scoreProtocolError, fmt.Errorf("%w: %s", ErrBadAfter, m.After))
+		return
+	}
+	page := p.headers.Next(MaxHeaders)
+	reply := &Headers{More: !p.headers.Done()}
+	reply.Headers = make([]block.Header, len(page))
+	for i, node := range page {
+		reply.Headers[i] = node.Block.Header
+		p.markKnown(InvItem{Kind: InvBlock, ID: node.Block.ID})
+	}
+	p.reply(reply)
+}
@@ -0,0 +1,285 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// MaxMessageSize bounds a message's payload, so a peer cannot make us
+// allocate more than this for one read.
+const MaxMessageSize = 1 << 25
+
+// Wire failures. A peer sending either is penalized in full.
+var (
+	ErrMalformed   = errors.New("p2p: malformed message")
+	ErrMessageSize = errors.New("p2p: message too large")
+)
+
+// Message is one of the protocol messages below.
+type Message interface {
+	command() byte
+}
+
+// Version opens the handshake. Each side sends one and answers the
+// other's with VerAck.
+type Version struct {
+	Version uint32
+	Genesis string // genesis block ID; peers on other networks are refused
+	Nonce   uint64 // random per node, to detect connections to self
+}
+
+// VerAck accepts the peer's Version.
+type VerAck struct{}
+
+// InvKind says what an InvItem names.
+type InvKind uint8
+
+const (
+	InvBlock InvKind = iota + 1
+	InvTx
+)
+
+func (k InvKind) String() string {
+	switch k {
+	case InvBlock:
+		return "block"
+	case InvTx:
+		return "tx"
+	}
+	return "InvKind(?)"
+}
+
+// InvItem names a block or transaction by ID.
+type InvItem struct {
+	Kind InvKind
+	ID   string
+}
+
+// Inv announces items the sender has.
+type Inv struct{ Items []InvItem }
+
+// GetData asks for announced items. They come back as BlockMsg and TxMsg,
+// or in a NotFound.
+type GetData struct{ Items []InvItem }
+
+// NotFound lists requested items the sender does not have.
+type NotFound struct{ Items []InvItem }
+
+// GetHeaders asks for the headers of the blocks the sender may be
+// missing: those outside the past of the first Locator block the receiver
+// has, in the order of dag.View.AntiPast. After, if set, continues the
+// previous answer past its last header, which must have that ID; the
+// Locator is then ignored.
+type GetHeaders struct {
+	Locator []string
+	After   string
+}
+
+// Headers answers GetHeaders with up to MaxHeaders headers. More is set
+// when the answer was cut short.
+type Headers struct {
+	Headers []block.Header
+	More    bool
+}
+
+// MaxHeaders bounds the headers in one Headers message.
+const MaxHeaders = 2000
+
+// BlockMsg carries a block.
+type BlockMsg struct{ Block *block.Block }
+
+// TxMsg carries a transaction.
+type TxMsg struct{ Tx block.TX }
+
+const (
+	cmdVersion byte = iota + 1
+	cmdVerAck
+	cmdInv
+	cmdGetData
+	cmdNotFound
+	cmdBlock
+	cmdTx
+	cmdGetHeaders
+	cmdHeaders
+)
+
+func (*Version) command() byte    { return cmdVersion }
+func (*VerAck) command() byte     { return cmdVerAck }
+func (*Inv) command() byte        { return cmdInv }
+func (*GetData) command() byte    { return cmdGetData }
+func (*NotFound) command() byte   { return cmdNotFound }
+func (*BlockMsg) command() byte   { return cmdBlock }
+func (*TxMsg) command() byte      { return cmdTx }
+func (*GetHeaders) command() byte { return cmdGetHeaders }
+func (*Headers) command() byte    { return cmdHeaders }
+
+// WriteMessage writes m as a 4-byte big-endian payload length, a command
+// byte and the payload.
+func WriteMessage(w io.Writer, m Message) error {
+	var payload []byte
+	switch m := m.(type) {
+	case *Version:
+		payload = binary.AppendUvarint(payload, uint64(m.Version))
+		payload = block.AppendString(payload, m.Genesis)
+		payload = binary.BigEndian.AppendUint64(payload, m.Nonce)
+	case *VerAck:
+	case *Inv:
+		payload = appendItems(payload, m.Items)
+	case *GetData:
+		payload = appendItems(payload, m.Items)
+	case *NotFound:
+		payload = appendItems(payload, m.Items)
+	case *GetHeaders:
+		payload = binary.AppendUvarint(payload, uint64(len(m.Locator)))
+		for _, id := range m.Locator {
+			payload = block.AppendString(payload, id)
+		}
+		payload = block.AppendString(payload, m.After)
+	case *Headers:
+		payload = binary.AppendUvarint(payload, uint64(len(m.Headers)))
+		for i := range m.Headers {
+			payload = block.AppendBytes(payload, m.Headers[i].Encode())
+		}
+		if m.More {
+			payload = append(payload, 1)
+		} else {
+			payload = append(payload, 0)
+		}
+	case *BlockMsg:
+		payload = m.Block.Encode()
+	case *TxMsg:
+		payload = m.Tx.EncodeWitness()
+	default:
+		return fmt.Errorf("p2p: cannot write %T", m)
+	}
+	if len(payload) > MaxMessageSize {
+		return ErrMessageSize
+	}
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	buf[4] = m.command()
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// ReadMessage reads a message written by WriteMessage. Errors from r are
+// returned as they are; bad input wraps ErrMalformed or is ErrMessageSize.
+func ReadMessage(r io.Reader) (Message, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if size > MaxMessageSize {
+		return nil, ErrMessageSize
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	d := block.NewDecoder(payload)
+	var m Message
+	switch head[4] {
+	case cmdVersion:
+		v := &Version{}
+		if ver := d.Uvarint(); ver <= 1<<32-1 {
+			v.Version = uint32(ver)
+		} else {
+			d.Fail()
+		}
+		v.Genesis = d.String()
+		v.Nonce = d.Uint64()
+		m = v
+	case cmdVerAck:
+		m = &VerAck{}
+	case cmdInv:
+		m = &Inv{Items: readItems(d)}
+	case cmdGetData:
+		m = &GetData{Items: readItems(d)}
+	case cmdNotFound:
+		m = &NotFound{Items: readItems(d)}
+	case cmdGetHeaders:
+		gh := &GetHeaders{}
+		if k := d.Count(1); k > 0 {
+			gh.Locator = make([]string, k)
+			for i := range gh.Locator {
+				gh.Locator[i] = d.String()
+			}
+		}
+		gh.After = d.String()
+		m = gh
+	case cmdHeaders:
+		h := &Headers{}
+		if k := d.Count(1); k > 0 {
+			h.Headers = make([]block.Header, k)
+			for i := range h.Headers {
+				hdr, err := block.DecodeHeader(d.Bytes())
+				if err != nil {
+					d.Fail()
+					break
+				}
+				h.Headers[i] = *hdr
+			}
+		}
+		switch d.Byte() {
+		case 0:
+		case 1:
+			h.More = true
+		default:
+			d.Fail()
+		}
+		m = h
+	case cmdBlock:
+		blk, err := block.DecodeBlock(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: block: %v", ErrMalformed, err)
+		}
+		return &BlockMsg{Block: blk}, nil
+	case cmdTx:
+		tx, err := block.DecodeTX(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: tx: %v", ErrMalformed, err)
+		}
+		return &TxMsg{Tx: tx}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown command %d", ErrMalformed, head[4])
+	}
+	if err := d.Done(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return m, nil
+}
+
+func appendItems(b []byte, items []InvItem) []byte {
+	b = binary.AppendUvarint(b, uint64(len(items)))
+	for _, it := range items {
+		b = append(b, byte(it.Kind))
+		b = block.AppendString(b, it.ID)
+	}
+	return b
+}
+
+// readItems reads what appendItems writes.
+func readItems(d *block.Decoder) []InvItem {
+	n := d.Count(2)
+	if n == 0 {
+		return nil
+	}
+	items := make([]InvItem, n)
+	for i := range items {
+		items[i].Kind = InvKind(d.Byte())
+		items[i].ID = d.String()
+		if items[i].Kind != InvBlock && items[i].Kind != InvTx {
+			d.Fail()
+		}
+	}
+	return items
+}
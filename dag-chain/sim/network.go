@@ -0,0 +1,446 @@
+package sim
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/miner"
+	"github.com/Abdullah-zahoor/dagchain/orphan"
+)
+
+// Link is how messages travel from one validator to another.
+type Link struct {
+	Latency time.Duration // fixed delay
+	Jitter  time.Duration // uniform extra delay in [0, Jitter)
+	Loss    float64       // probability a message is dropped
+}
+
+// NetConfig sets up a Network. Zero fields take the defaults noted.
+type NetConfig struct {
+	Validators int   // 0 means 4
+	Seed       int64 // the only source of randomness
+	// BlockInterval is the mean virtual time between blocks, network-wide;
+	// each block goes to a validator picked at random. 0 means 1s.
+	BlockInterval time.Duration
+	MaxParents    int  // tips each block merges; 0 means 2
+	Link          Link // every link, until SetLink
+	// SyncInterval is how often each validator asks a random peer for the
+	// blocks it lacks, which repairs losses. 0 means 10 * BlockInterval.
+	SyncInterval   time.Duration
+	SampleInterval time.Duration // 0 means BlockInterval
+	Merge          dag.MergeRule // nil means dag.HeightOrder
+	Params         *dag.Params   // nil means dag.DefaultParams(); Now is replaced by the virtual clock
+	Start          time.Time     // virtual time at the start; zero means the Unix time 1700000000
+}
+
+// Validator is one simulated node with its own view of the DAG.
+type Validator struct {
+	ID  int
+	DAG *dag.DAG
+
+	orphans *orphan.Pool
+	builder *miner.Builder
+	mined   int
+}
+
+// Sample is the state of the network at one virtual instant.
+type Sample struct {
+	Time     time.Time
+	Blocks   int     // in the largest validator DAG
+	MeanTips float64 // DAG width, averaged over validators
+	MaxTips  int
+	Agree    bool // every validator has the same heaviest tip
+}
+
+type eventKind int
+
+const (
+	evMine eventKind = iota
+	evSync           // a validator's periodic sync request
+	evSample
+	evDeliver
+)
+
+type event struct {
+	at   time.Time
+	seq  uint64 // ties broken by scheduling order
+	kind eventKind
+	from int
+	to   int
+	msg  message
+}
+
+// message is what travels over a link: a block, a request for the blocks
+// the sender lacks, or the reply.
+type message struct {
+	block   *block.Block
+	locator []string
+	blocks  []*block.Block
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Network is a discrete-event simulation of validators that each keep
+// their own DAG and exchange blocks over links with latency and loss. Time
+// is virtual and all randomness comes from the seed, so a configuration
+// and seed always produce the same DAGs. A Network is not safe for
+// concurrent use.
+type Network struct {
+	cfg        NetConfig
+	rng        *rand.Rand
+	now        time.Time
+	queue      eventQueue
+	seq        uint64
+	validators []*Validator
+	links      map[[2]int]Link
+	group      []int // partition of each validator
+	mining     bool
+	paused     bool // a block came due while not mining
+	samples    []Sample
+}
+
+// NewNetwork creates the validators on a shared genesis and schedules the
+// first block.
+func NewNetwork(cfg NetConfig) (*Network, error) {
+	if cfg.Validators <= 0 {
+		cfg.Validators = 4
+	}
+	if cfg.BlockInterval <= 0 {
+		cfg.BlockInterval = time.Second
+	}
+	if cfg.MaxParents <= 0 {
+		cfg.MaxParents = 2
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = 10 * cfg.BlockInterval
+	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = cfg.BlockInterval
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Unix(1700000000, 0)
+	}
+	n := &Network{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+		now:    cfg.Start,
+		links:  make(map[[2]int]Link),
+		group:  make([]int, cfg.Validators),
+		mining: true,
+	}
+	genesis := block.NewBlock(nil, nil, cfg.Start)
+	for i := 0; i < cfg.Validators; i++ {
+		d := dag.NewDAG()
+		d.Merge = cfg.Merge
+		if cfg.Params != nil {
+			d.Params = *cfg.Params
+		} else {
+			d.Params = dag.DefaultParams()
+		}
+		d.Params.Now = n.Now
+		if err := d.AddGenesis(genesis, block.NewUTXOTrie()); err != nil {
+			return nil, err
+		}
+		seed := sha256.Sum256([]byte(fmt.Sprintf("validator-%d", i)))
+		key := block.KeyFromSeed(seed[:])
+		v := &Validator{
+			ID:      i,
+			DAG:     d,
+			orphans: orphan.New(d, orphan.Config{Now: n.Now}),
+			builder: miner.New(d, miner.Config{
+				Parents: miner.HeaviestTips{Max: cfg.MaxParents},
+				Payout:  key.Address(),
+				Key:     key,
+			}),
+		}
+		n.validators = append(n.validators, v)
+		n.schedule(&event{at: n.now.Add(n.jitter(cfg.SyncInterval)), kind: evSync, from: i})
+	}
+	n.scheduleMine()
+	n.schedule(&event{at: n.now, kind: evSample})
+	return n, nil
+}
+
+// Now returns the virtual time.
+func (n *Network) Now() time.Time { return n.now }
+
+// Validators returns the validators, by ID.
+func (n *Network) Validators() []*Validator { return n.validators }
+
+// Samples returns the samples taken so far, oldest first.
+func (n *Network) Samples() []Sample { return n.samples }
+
+// SetLink sets the link between validators a and b, both ways.
+func (n *Network) SetLink(a, b int, l Link) {
+	n.links[[2]int{min(a, b), max(a, b)}] = l
+}
+
+func (n *Network) link(a, b int) Link {
+	if l, ok := n.links[[2]int{min(a, b), max(a, b)}]; ok {
+		return l
+	}
+	return n.cfg.Link
+}
+
+// Partition splits the network: validators in different groups cannot
+// reach each other, and messages between them in flight are lost.
+// Validators in no group form one more group.
+func (n *Network) Partition(groups ...[]int) {
+	for i := range n.group {
+		n.group[i] = 0
+	}
+	for g, ids := range groups {
+		for _, id := range ids {
+			n.group[id] = g + 1
+		}
+	}
+}
+
+// Heal removes the partition. Every validator asks every other for the
+// blocks it lacks.
+func (n *Network) Heal() {
+	n.Partition()
+	for _, v := range n.validators {
+		for _, w := range n.validators {
+			if v != w {
+				n.send(v.ID, w.ID, message{locator: v.DAG.Locator()})
+			}
+		}
+	}
+}
+
+// RunFor processes events for d of virtual time.
+func (n *Network) RunFor(d time.Duration) error {
+	end := n.now.Add(d)
+	for len(n.queue) > 0 && !n.queue[0].at.After(end) {
+		if err := n.step(heap.Pop(&n.queue).(*event)); err != nil {
+			return err
+		}
+	}
+	n.now = end
+	return nil
+}
+
+// Settle stops mining and runs until every validator holds the same
+// blocks, or limit of virtual time has passed, then resumes mining. It
+// reports whether the validators converged.
+func (n *Network) Settle(limit time.Duration) (bool, error) {
+	n.mining = false
+	defer func() {
+		n.mining = true
+		if n.paused {
+			n.paused = false
+			n.scheduleMine()
+		}
+	}()
+	end := n.now.Add(limit)
+	for !n.Converged() {
+		if len(n.queue) == 0 || n.queue[0].at.After(end) {
+			n.now = end
+			return false, nil
+		}
+		if err := n.step(heap.Pop(&n.queue).(*event)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Converged reports whether every validator holds the same blocks and
+// agrees on the heaviest tip.
+func (n *Network) Converged() bool {
+	first := n.validators[0].DAG
+	tip := first.HeaviestTip().Block.ID
+	view := first.View()
+	for _, v := range n.validators[1:] {
+		if v.DAG.Len() != view.Len() || v.DAG.HeaviestTip().Block.ID != tip {
+			return false
+		}
+		for id := range view.Nodes() {
+			if v.DAG.Node(id) == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (n *Network) schedule(e *event) {
+	e.seq = n.seq
+	n.seq++
+	heap.Push(&n.queue, e)
+}
+
+// jitter returns a random duration in [0, d).
+func (n *Network) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(n.rng.Int63n(int64(d)))
+}
+
+// scheduleMine schedules the next block an exponentially distributed time
+// from now, at least a millisecond so timestamps keep increasing.
+func (n *Network) scheduleMine() {
+	wait := time.Duration(n.rng.ExpFloat64() * float64(n.cfg.BlockInterval))
+	n.schedule(&event{at: n.now.Add(max(wait, time.Millisecond)), kind: evMine, from: n.rng.Intn(len(n.validators))})
+}
+
+// send puts msg on the link from one validator to another, unless the
+// link drops it or a partition separates them.
+func (n *Network) send(from, to int, msg message) {
+	l := n.link(from, to)
+	if n.group[from] != n.group[to] || (l.Loss > 0 && n.rng.Float64() < l.Loss) {
+		return
+	}
+	n.schedule(&event{at: n.now.Add(l.Latency + n.jitter(l.Jitter)), kind: evDeliver, from: from, to: to, msg: msg})
+}
+
+func (n *Network) step(e *event) error {
+	n.now = e.at
+	switch e.kind {
+	case evMine:
+		if !n.mining {
+			n.paused = true // Settle reschedules it when done
+			return nil
+		}
+		n.scheduleMine()
+		return n.mine(n.validators[e.from])
+	case evSync:
+		n.schedule(&event{at: n.now.Add(n.cfg.SyncInterval), kind: evSync, from: e.from})
+		if len(n.validators) > 1 {
+			peer := n.rng.Intn(len(n.validators) - 1)
+			if peer >= e.from {
+				peer++
+			}
+			n.send(e.from, peer, message{locator: n.validators[e.from].DAG.Locator()})
+		}
+	case evSample:
+		n.schedule(&event{at: n.now.Add(n.cfg.SampleInterval), kind: evSample})
+		n.sample()
+	case evDeliver:
+		if n.group[e.from] != n.group[e.to] {
+			return nil // cut off while in flight
+		}
+		return n.deliver(n.validators[e.to], e.from, e.msg)
+	}
+	return nil
+}
+
+func (n *Network) mine(v *Validator) error {
+	v.mined++
+	blk, err := v.builder.Build(n.now, []byte(fmt.Sprintf("v%d-%d", v.ID, v.mined)))
+	if err != nil {
+		return fmt.Errorf("validator %d: %w", v.ID, err)
+	}
+	return n.accept(v, -1, []*block.Block{blk})
+}
+
+func (n *Network) deliver(v *Validator, from int, msg message) error {
+	switch {
+	case msg.block != nil:
+		return n.accept(v, from, []*block.Block{msg.block})
+	case msg.locator != nil:
+		view := v.DAG.View()
+		missing := view.AntiPast(view.FindFork(msg.locator))
+		if len(missing) == 0 {
+			return nil
+		}
+		blocks := make([]*block.Block, len(missing))
+		for i, m := range missing {
+			blocks[i] = m.Block
+		}
+		n.send(v.ID, from, message{blocks: blocks})
+	default:
+		return n.accept(v, from, msg.blocks)
+	}
+	return nil
+}
+
+// accept adds blocks through v's orphan pool and relays every block that
+// connects to all peers but the one it came from. A block left waiting on
+// its parents makes v ask the sender for what it lacks.
+func (n *Network) accept(v *Validator, from int, blocks []*block.Block) error {
+	orphaned := false
+	for _, blk := range blocks {
+		if v.DAG.Node(blk.ID) != nil || v.orphans.Has(blk.ID) {
+			continue
+		}
+		connected, _, err := v.orphans.Add(blk)
+		if err != nil {
+			if dag.IsInvalid(err) {
+				return fmt.Errorf("validator %d: %w", v.ID, err)
+			}
+			continue
+		}
+		if len(connected) == 0 {
+			orphaned = true
+		}
+		for _, c := range connected {
+			for _, w := range n.validators {
+				if w != v && w.ID != from {
+					n.send(v.ID, w.ID, message{block: c})
+				}
+			}
+		}
+	}
+	if orphaned && from >= 0 {
+		n.send(v.ID, from, message{locator: v.DAG.Locator()})
+	}
+	return nil
+}
+
+func (n *Network) sample() {
+	s := Sample{Time: n.now, Agree: true}
+	tip := n.validators[0].DAG.HeaviestTip()
+	total := 0
+	for _, v := range n.validators {
+		s.Blocks = max(s.Blocks, v.DAG.Len())
+		tips := len(v.DAG.Tips())
+		total += tips
+		s.MaxTips = max(s.MaxTips, tips)
+		if v.DAG.HeaviestTip() != nil && v.DAG.HeaviestTip().Block.ID != tip.Block.ID {
+			s.Agree = false
+		}
+	}
+	s.MeanTips = float64(total) / float64(len(n.validators))
+	n.samples = append(n.samples, s)
+}
+
+// Fingerprint summarizes every validator's DAG: its block IDs, sorted, and
+// heaviest tip. Runs with the same configuration and seed give the same
+// fingerprint.
+func (n *Network) Fingerprint() string {
+	h := sha256.New()
+	for _, v := range n.validators {
+		ids := make([]string, 0, v.DAG.Len())
+		for id := range v.DAG.View().Nodes() {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprintf(h, "%d %s %v\n", v.ID, v.DAG.HeaviestTip().Block.ID, ids)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,161 @@
+package sim_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/sim"
+)
+
+func newNetwork(t *testing.T, cfg sim.NetConfig) *sim.Network {
+	t.Helper()
+	n, err := sim.NewNetwork(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func run(t *testing.T, n *sim.Network, d time.Duration) {
+	t.Helper()
+	if err := n.RunFor(d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func settle(t *testing.T, n *sim.Network) {
+	t.Helper()
+	ok, err := n.Settle(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("validators did not converge")
+	}
+}
+
+func TestNetwork_SameSeedSameDAG(t *testing.T) {
+	cfg := sim.NetConfig{
+		Validators: 5,
+		Seed:       42,
+		Link:       sim.Link{Latency: 100 * time.Millisecond, Jitter: 400 * time.Millisecond, Loss: 0.1},
+		Merge:      consensus.GhostDAG{K: 3},
+	}
+	fingerprint := func(cfg sim.NetConfig) string {
+		n := newNetwork(t, cfg)
+		run(t, n, 30*time.Second)
+		return n.Fingerprint()
+	}
+	a, b := fingerprint(cfg), fingerprint(cfg)
+	if a != b {
+		t.Fatal("two runs with the same seed differ")
+	}
+	cfg.Seed++
+	if fingerprint(cfg) == a {
+		t.Error("runs with different seeds are identical")
+	}
+}
+
+func TestNetwork_PartitionAndHeal(t *testing.T) {
+	n := newNetwork(t, sim.NetConfig{
+		Validators: 6,
+		Seed:       7,
+		Link:       sim.Link{Latency: 50 * time.Millisecond, Jitter: 50 * time.Millisecond},
+		Merge:      consensus.GhostDAG{K: 3},
+	})
+	run(t, n, 20*time.Second)
+	settle(t, n)
+	before := n.Validators()[0].DAG.Len()
+
+	n.Partition([]int{0, 1, 2}, []int{3, 4, 5})
+	run(t, n, 30*time.Second)
+	left, right := n.Validators()[0].DAG, n.Validators()[3].DAG
+	if left.HeaviestTip().Block.ID == right.HeaviestTip().Block.ID {
+		t.Fatal("the two sides of the partition agree on a tip")
+	}
+	if n.Converged() {
+		t.Fatal("partitioned validators converged")
+	}
+	disagreed := false
+	for _, s := range n.Samples() {
+		disagreed = disagreed || !s.Agree
+	}
+	if !disagreed {
+		t.Error("no sample shows the partition")
+	}
+	leftTip, rightTip := left.HeaviestTip().Block.ID, right.HeaviestTip().Block.ID
+	onlyLeft, onlyRight := left.Len()-before, right.Len()-before
+
+	n.Heal()
+	settle(t, n)
+	// every validator has both sides' blocks, so the DAG is at least as
+	// wide as the two sides' tips
+	if got, want := left.Len(), before+onlyLeft+onlyRight; got != want {
+		t.Errorf("%d blocks after healing, want %d", got, want)
+	}
+	if len(left.Tips()) < 2 {
+		t.Errorf("DAG width %d right after healing", len(left.Tips()))
+	}
+
+	// the next blocks merge the two sides and consensus reconverges
+	run(t, n, 10*time.Second)
+	settle(t, n)
+	tip := left.HeaviestTip()
+	if !inPast(tip, leftTip) || !inPast(tip, rightTip) {
+		t.Error("heaviest tip does not merge both sides of the partition")
+	}
+}
+
+func inPast(n *dag.Node, id string) bool {
+	seen := map[*dag.Node]bool{}
+	queue := []*dag.Node{n}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if c.Block.ID == id {
+			return true
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return false
+}
+
+func TestNetwork_LossIsRepaired(t *testing.T) {
+	n := newNetwork(t, sim.NetConfig{
+		Validators:   4,
+		Seed:         3,
+		Link:         sim.Link{Latency: 20 * time.Millisecond, Loss: 0.5},
+		SyncInterval: 2 * time.Second,
+	})
+	run(t, n, 20*time.Second)
+	settle(t, n)
+	if n.Validators()[0].DAG.Len() < 10 {
+		t.Errorf("only %d blocks in 20s", n.Validators()[0].DAG.Len())
+	}
+}
+
+func TestNetwork_MinesAtLowDifficulty(t *testing.T) {
+	params := dag.DefaultParams()
+	params.ProofOfWork = true
+	params.MinDifficulty = 16
+	n := newNetwork(t, sim.NetConfig{Validators: 3, Seed: 1, Params: &params})
+	run(t, n, 10*time.Second)
+	settle(t, n)
+
+	d := n.Validators()[0].DAG
+	if d.Len() < 4 {
+		t.Fatalf("only %d blocks after the run", d.Len())
+	}
+	for _, node := range d.View().Nodes() {
+		if node != d.Genesis() && (node.Block.Difficulty < 16 || !node.Block.MeetsTarget()) {
+			t.Errorf("block %s at difficulty %d does not carry its work", node.Block.ID, node.Block.Difficulty)
+		}
+	}
+}
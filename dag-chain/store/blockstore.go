@@ -0,0 +1,506 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+)
+
+// Block log records. recBlock holds a block as added to the DAG with the
+// consensus data AddBlock computed for it; recRemove undoes one, as
+// pruning does. The checkpoint starts with a recCheckpoint naming its
+// block, followed by opPut records for that block's UTXO set.
+const (
+	recBlock byte = iota + 1
+	recRemove
+	recCheckpoint
+)
+
+// maxBlockRecord bounds a block log or checkpoint payload, far above any
+// block the default params accept and so above any output in one.
+const maxBlockRecord = 1 << 26
+
+const (
+	blockLogName   = "blocks.log"
+	checkpointName = "checkpoint"
+)
+
+// ErrNoCheckpoint is returned by Load for a directory without a
+// checkpoint, which every store needs from genesis on.
+var ErrNoCheckpoint = errors.New("store: no checkpoint")
+
+// BlockStore persists a DAG in a directory. blocks.log is an append-only
+// log of every block added or removed, each added block with its consensus
+// data: height, weight, selected parent, merge set and coloring. The
+// checkpoint file holds the UTXO set of one block, normally the finality
+// point, and is replaced atomically.
+//
+// Load restores the DAG from the log without validating or coloring
+// anything again. Only blocks whose selected chain runs through the
+// checkpoint have their transactions executed, to rebuild their UTXO
+// state; the rest, the checkpoint's past and branches that forked below
+// it, come back without state.
+type BlockStore struct {
+	dir  string
+	mu   sync.Mutex
+	log  *os.File
+	size int64
+}
+
+// OpenBlockStore opens or creates a store in dir. A torn record at the
+// end of the log, left by a crash mid-write, is truncated; a bad record
+// anywhere else is ErrCorrupt.
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, blockLogName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &BlockStore{dir: dir, log: f}
+	end, err := scanFrames(f, maxBlockRecord, func(int64, []byte) error { return nil })
+	if err == nil {
+		// torn tail: keep everything before it
+		err = f.Truncate(end)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("store: %s: %w", blockLogName, err)
+	}
+	s.size = end
+	return s, nil
+}
+
+// Empty reports whether the log holds no records, as for a new store.
+func (s *BlockStore) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size == 0
+}
+
+// Close syncs and closes the log.
+func (s *BlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.log.Sync(), s.log.Close())
+}
+
+func (s *BlockStore) append(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := frame(payload)
+	if _, err := s.log.WriteAt(rec, s.size); err != nil {
+		return err
+	}
+	s.size += int64(len(rec))
+	return nil
+}
+
+// Put records n as added. Its parents must have been put before it.
+func (s *BlockStore) Put(n *dag.Node) error {
+	return s.append(encodeNode(n))
+}
+
+// Remove records that the block was removed from the DAG.
+func (s *BlockStore) Remove(id string) error {
+	return s.append(block.AppendString([]byte{recRemove}, id))
+}
+
+// Checkpoint makes n's UTXO set the checkpoint. n must have been put, and
+// the log is synced first so the checkpoint never names a block lost in a
+// crash. The genesis is the exception: checkpointed before anything is
+// logged, a crash in between leaves a store that is still Empty.
+func (s *BlockStore) Checkpoint(n *dag.Node) error {
+	if n.UTXO == nil {
+		return fmt.Errorf("store: checkpoint %s: %w", n.Block.ID, dag.ErrNoState)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, checkpointName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.Write(frame(block.AppendString([]byte{recCheckpoint}, n.Block.ID)))
+	var werr error
+	err = n.UTXO.Iterate(func(key block.UTXOKey, out block.TXOutput) bool {
+		var rec []byte
+		if rec, werr = encodeRecord(opPut, key, out, maxBlockRecord); werr == nil {
+			_, werr = w.Write(rec)
+		}
+		return werr == nil
+	})
+	err = errors.Join(err, werr)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, checkpointName)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// Follow persists d's changes as subscribers hear of them: added blocks,
+// pruned blocks, and each new finality point as the checkpoint. The
+// genesis is not announced, so put and checkpoint it before following.
+// Following stops at the first failed write: the subscription ends at
+// once, so the DAG does not wait on it, and failed, if not nil, is called
+// with the error from the following goroutine. stop ends the subscription,
+// waits for the events already delivered to be written, and returns that
+// error.
+func (s *BlockStore) Follow(d *dag.DAG, buffer int, failed func(error)) (stop func() error) {
+	sub := d.Subscribe(buffer)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		for ev := range sub.C {
+			switch ev.Kind {
+			case dag.EventBlockAdded:
+				err = s.Put(ev.Block)
+			case dag.EventPruned:
+				for _, n := range ev.Nodes {
+					if err = s.Remove(n.Block.ID); err != nil {
+						break
+					}
+				}
+			case dag.EventFinalized:
+				err = s.Checkpoint(ev.Block)
+			}
+			if err != nil {
+				sub.Unsubscribe()
+				if failed != nil {
+					failed(err)
+				}
+				return
+			}
+		}
+	}()
+	return func() error {
+		sub.Unsubscribe()
+		<-done
+		return err
+	}
+}
+
+// Loaded summarizes what Load restored.
+type Loaded struct {
+	Blocks     int    // nodes restored
+	Replayed   int    // of those, blocks whose transactions were executed
+	Checkpoint string // block whose state came from the checkpoint
+}
+
+// Load restores the stored DAG into d, which must be empty and configured
+// with the same Merge rule and Params it was built under. A replayed
+// block's UTXO set must match its header's UTXORoot when it has one, and
+// so must the checkpoint's. Errors of rejected merge-set transactions come
+// back as text only.
+func (s *BlockStore) Load(d *dag.DAG) (*Loaded, error) {
+	cpID, cpState, err := s.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	recs, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Loaded{Checkpoint: cpID}
+	for _, rec := range recs {
+		n, err := rec.node(d)
+		if err != nil {
+			return nil, err
+		}
+		blk := n.Block
+		switch {
+		case blk.ID == cpID:
+			if n.UTXO, err = block.Commit(cpState); err != nil {
+				return nil, err
+			}
+		case n.SelectedParent != nil && n.SelectedParent.UTXO != nil:
+			m, err := d.MergeParents(n.Parents)
+			if err != nil {
+				return nil, fmt.Errorf("store: replay %s: %w", blk.ID, err)
+			}
+			if _, err := block.ApplyBlock(m.State, blk.TXs, block.Subsidy(n.Height)); err != nil {
+				return nil, fmt.Errorf("store: replay %s: %w", blk.ID, err)
+			}
+			n.UTXO = m.State
+			res.Replayed++
+		}
+		if n.UTXO != nil && blk.UTXORoot != "" && n.UTXO.Root() != blk.UTXORoot {
+			return nil, fmt.Errorf("store: block %s: %w", blk.ID, dag.ErrUTXORootMismatch)
+		}
+		if err := d.Restore(n); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		res.Blocks++
+	}
+	if d.Node(cpID) == nil {
+		return nil, fmt.Errorf("store: checkpoint block %s is not in the log: %w", cpID, ErrCorrupt)
+	}
+	return res, nil
+}
+
+func (s *BlockStore) readCheckpoint() (string, block.UTXOStore, error) {
+	f, err := os.Open(filepath.Join(s.dir, checkpointName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, ErrNoCheckpoint
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	payload, err := readFrame(r, maxBlockRecord)
+	if err != nil || len(payload) == 0 || payload[0] != recCheckpoint {
+		return "", nil, fmt.Errorf("store: checkpoint: %w", ErrCorrupt)
+	}
+	dec := block.NewDecoder(payload[1:])
+	id := dec.String()
+	if err := dec.Done(); err != nil {
+		return "", nil, fmt.Errorf("store: checkpoint: %w: %v", ErrCorrupt, err)
+	}
+	state := block.NewUTXOTrie()
+	for {
+		op, key, out, _, err := readRecord(r, maxBlockRecord)
+		if err == io.EOF {
+			break
+		}
+		// the file was synced before it was renamed into place, so unlike
+		// the log it has no torn tail
+		if err != nil || op != opPut {
+			return "", nil, fmt.Errorf("store: checkpoint: %w", ErrCorrupt)
+		}
+		state.Put(key, out)
+	}
+	return id, state, nil
+}
+
+// readLog returns the records of blocks still in the DAG, in log order.
+func (s *BlockStore) readLog() ([]*nodeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := bufio.NewReader(io.NewSectionReader(s.log, 0, s.size))
+	var recs []*nodeRecord
+	live := make(map[string]*nodeRecord)
+	for {
+		payload, err := readFrame(r, maxBlockRecord)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(payload) > 0 && payload[0] == recBlock:
+			rec, err := decodeNode(payload[1:])
+			if err != nil {
+				return nil, err
+			}
+			if live[rec.blk.ID] != nil {
+				return nil, fmt.Errorf("store: block %s put twice: %w", rec.blk.ID, ErrCorrupt)
+			}
+			live[rec.blk.ID] = rec
+			recs = append(recs, rec)
+		case len(payload) > 0 && payload[0] == recRemove:
+			dec := block.NewDecoder(payload[1:])
+			id := dec.String()
+			if err := dec.Done(); err != nil {
+				return nil, fmt.Errorf("store: remove record: %w: %v", ErrCorrupt, err)
+			}
+			if rec := live[id]; rec != nil {
+				rec.removed = true
+				delete(live, id)
+			}
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+	kept := recs[:0]
+	for _, rec := range recs {
+		if !rec.removed {
+			kept = append(kept, rec)
+		}
+	}
+	return kept, nil
+}
+
+// nodeRecord is a decoded recBlock: the block and its consensus data with
+// nodes named by ID.
+type nodeRecord struct {
+	blk                   *block.Block
+	height, weight, blue  uint64
+	blueWork              uint64
+	selected              string
+	mergeSet, blues, reds []string
+	bluesAnticone         map[string]int
+	rejected              []rejectedRecord
+	removed               bool
+}
+
+type rejectedRecord struct {
+	blockID, txID, err string
+}
+
+// node resolves the record's IDs against d, where every block it names
+// must be by now.
+func (rec *nodeRecord) node(d *dag.DAG) (*dag.Node, error) {
+	var missing string
+	lookup := func(id string) *dag.Node {
+		n := d.Node(id)
+		if n == nil && missing == "" {
+			missing = id
+		}
+		return n
+	}
+	lookupAll := func(ids []string) []*dag.Node {
+		if ids == nil {
+			return nil
+		}
+		nodes := make([]*dag.Node, len(ids))
+		for i, id := range ids {
+			nodes[i] = lookup(id)
+		}
+		return nodes
+	}
+	n := &dag.Node{
+		Block:     rec.blk,
+		Parents:   lookupAll(rec.blk.Parents),
+		Weight:    rec.weight,
+		Height:    rec.height,
+		MergeSet:  lookupAll(rec.mergeSet),
+		BlueScore: rec.blue,
+		BlueWork:  rec.blueWork,
+		Blues:     lookupAll(rec.blues),
+		Reds:      lookupAll(rec.reds),
+	}
+	if rec.selected != "" {
+		n.SelectedParent = lookup(rec.selected)
+	}
+	if rec.bluesAnticone != nil {
+		n.BluesAnticone = make(map[*dag.Node]int, len(rec.bluesAnticone))
+		for id, size := range rec.bluesAnticone {
+			n.BluesAnticone[lookup(id)] = size
+		}
+	}
+	for _, r := range rec.rejected {
+		n.Rejected = append(n.Rejected, dag.RejectedTx{BlockID: r.blockID, TxID: r.txID, Err: errors.New(r.err)})
+	}
+	if missing != "" {
+		return nil, fmt.Errorf("store: block %s refers to %s, which is not stored before it: %w", rec.blk.ID, missing, ErrCorrupt)
+	}
+	return n, nil
+}
+
+func appendIDs(b []byte, nodes []*dag.Node) []byte {
+	b = binary.AppendUvarint(b, uint64(len(nodes)))
+	for _, n := range nodes {
+		b = block.AppendString(b, n.Block.ID)
+	}
+	return b
+}
+
+func encodeNode(n *dag.Node) []byte {
+	b := block.AppendBytes([]byte{recBlock}, n.Block.Encode())
+	b = binary.AppendUvarint(b, n.Height)
+	b = binary.AppendUvarint(b, n.Weight)
+	b = binary.AppendUvarint(b, n.BlueScore)
+	b = binary.AppendUvarint(b, n.BlueWork)
+	var selected string
+	if n.SelectedParent != nil {
+		selected = n.SelectedParent.Block.ID
+	}
+	b = block.AppendString(b, selected)
+	b = appendIDs(b, n.MergeSet)
+	b = appendIDs(b, n.Blues)
+	b = appendIDs(b, n.Reds)
+	ids := make([]string, 0, len(n.BluesAnticone))
+	sizes := make(map[string]int, len(n.BluesAnticone))
+	for blue, size := range n.BluesAnticone {
+		ids = append(ids, blue.Block.ID)
+		sizes[blue.Block.ID] = size
+	}
+	sort.Strings(ids)
+	b = binary.AppendUvarint(b, uint64(len(ids)))
+	for _, id := range ids {
+		b = block.AppendString(b, id)
+		b = binary.AppendUvarint(b, uint64(sizes[id]))
+	}
+	b = binary.AppendUvarint(b, uint64(len(n.Rejected)))
+	for _, r := range n.Rejected {
+		b = block.AppendString(b, r.BlockID)
+		b = block.AppendString(b, r.TxID)
+		b = block.AppendString(b, r.Err.Error())
+	}
+	return b
+}
+
+func decodeNode(p []byte) (*nodeRecord, error) {
+	dec := block.NewDecoder(p)
+	rec := &nodeRecord{}
+	blk, err := block.DecodeBlock(dec.Bytes())
+	if err != nil {
+		dec.Fail()
+	}
+	rec.blk = blk
+	rec.height = dec.Uvarint()
+	rec.weight = dec.Uvarint()
+	rec.blue = dec.Uvarint()
+	rec.blueWork = dec.Uvarint()
+	rec.selected = dec.String()
+	rec.mergeSet = readIDs(dec)
+	rec.blues = readIDs(dec)
+	rec.reds = readIDs(dec)
+	if n := dec.Count(1); n > 0 {
+		rec.bluesAnticone = make(map[string]int, n)
+		for i := 0; i < n; i++ {
+			id := dec.String()
+			rec.bluesAnticone[id] = int(dec.Uvarint())
+		}
+	}
+	for i, n := 0, dec.Count(1); i < n; i++ {
+		rec.rejected = append(rec.rejected, rejectedRecord{dec.String(), dec.String(), dec.String()})
+	}
+	if err := dec.Done(); err != nil {
+		return nil, fmt.Errorf("store: block record: %w: %v", ErrCorrupt, err)
+	}
+	return rec, nil
+}
+
+// readIDs reads what appendIDs writes.
+func readIDs(dec *block.Decoder) []string {
+	n := dec.Count(1)
+	if n == 0 {
+		return nil
+	}
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = dec.String()
+	}
+	return ids
+}
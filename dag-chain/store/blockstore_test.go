@@ -0,0 +1,238 @@
+package store_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/consensus"
+	"github.com/Abdullah-zahoor/dagchain/dag"
+	"github.com/Abdullah-zahoor/dagchain/miner"
+	"github.com/Abdullah-zahoor/dagchain/store"
+)
+
+func newGhostDAG() *dag.DAG {
+	d := dag.NewDAG()
+	d.Merge = consensus.GhostDAG{K: 3}
+	return d
+}
+
+// buildStored grows a DAG while a BlockStore in dir follows it: a mined
+// chain with a merged side block, finalized to some depth and pruned of a
+// dead branch.
+func buildStored(t *testing.T, dir string) *dag.DAG {
+	t.Helper()
+	s, err := store.OpenBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newGhostDAG()
+	ts := time.Unix(1700000000, 0)
+	gen := block.NewBlock(nil, nil, ts)
+	// an output far larger than a UTXO log record, which the checkpoint
+	// must still hold
+	seed := block.TXOutput{Value: 100, Recipient: strings.Repeat("r", 1<<17)}
+	if err := d.AddGenesis(gen, block.UTXOSet{{TxID: "seed"}: seed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(d.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Checkpoint(d.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+	stop := s.Follow(d, 0, nil)
+
+	add := func(blk *block.Block) {
+		t.Helper()
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := miner.New(d, miner.Config{Parents: miner.HeaviestTips{Max: 2}, Payout: "m"})
+	for i := 1; i <= 8; i++ {
+		blk, err := b.Build(ts.Add(time.Duration(i)*time.Second), []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(blk)
+		if i == 2 {
+			// a side block that gets merged, and a dead one that gets pruned
+			side := d.Node(blk.Parents[0]).Block.ID
+			for _, extra := range []string{"merged", "dead"} {
+				cb := block.NewTX(nil, []block.TXOutput{{Value: 1, Recipient: extra}}, []byte(extra))
+				add(block.NewBlock([]string{side}, []block.TX{cb}, ts.Add(time.Duration(i)*time.Second)))
+			}
+		}
+	}
+	f := consensus.NewFinality(consensus.Depth{N: 3})
+	f.Update(d)
+	if _, err := consensus.Prune(d, f, make(consensus.MemArchive)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestBlockStore_Restore(t *testing.T) {
+	dir := t.TempDir()
+	orig := buildStored(t, dir)
+
+	s, err := store.OpenBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	d := newGhostDAG()
+	loaded, err := s.Load(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Blocks != orig.Len() || d.Len() != orig.Len() {
+		t.Fatalf("restored %d blocks, want %d", d.Len(), orig.Len())
+	}
+	if loaded.Replayed == 0 || loaded.Replayed >= loaded.Blocks-1 {
+		t.Errorf("replayed %d of %d blocks, want only those above the checkpoint", loaded.Replayed, loaded.Blocks)
+	}
+	for id, want := range orig.View().Nodes() {
+		got := d.Node(id)
+		if got == nil {
+			t.Fatalf("block %s not restored", id)
+		}
+		if got.Weight != want.Weight || got.Height != want.Height || got.BlueScore != want.BlueScore || got.BlueWork != want.BlueWork ||
+			len(got.MergeSet) != len(want.MergeSet) || len(got.Reds) != len(want.Reds) {
+			t.Errorf("block %s: consensus data differs after restore", id)
+		}
+		if (want.SelectedParent == nil) != (got.SelectedParent == nil) ||
+			(want.SelectedParent != nil && want.SelectedParent.Block.ID != got.SelectedParent.Block.ID) {
+			t.Errorf("block %s: selected parent differs after restore", id)
+		}
+		if got.UTXO != nil && got.UTXO.Root() != want.UTXO.Root() {
+			t.Errorf("block %s: restored state differs", id)
+		}
+	}
+	tip := d.HeaviestTip()
+	if tip.Block.ID != orig.HeaviestTip().Block.ID || tip.UTXO == nil {
+		t.Fatal("heaviest tip not restored with its state")
+	}
+	if d.Genesis().UTXO != nil {
+		t.Error("genesis state restored although the checkpoint moved past it")
+	}
+
+	// the restored DAG keeps growing
+	blk, err := miner.New(d, miner.Config{Payout: "m"}).Build(tip.Block.Timestamp.Add(time.Second), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockStore_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	orig := buildStored(t, dir)
+
+	// Simulate a crash in the middle of the next append
+	path := filepath.Join(dir, "blocks.log")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 9, 9, 9})
+	f.Close()
+
+	s, err := store.OpenBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	d := newGhostDAG()
+	if _, err := s.Load(d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != orig.Len() {
+		t.Fatalf("restored %d blocks after a torn write, want %d", d.Len(), orig.Len())
+	}
+}
+
+func TestBlockStore_CorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	buildStored(t, dir)
+
+	// Flip a bit inside the first record, with every later record intact
+	path := filepath.Join(dir, "blocks.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 1
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.OpenBlockStore(dir); !errors.Is(err, store.ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Error("corrupt log was truncated")
+	}
+}
+
+func TestBlockStore_NoCheckpoint(t *testing.T) {
+	s, err := store.OpenBlockStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.Empty() {
+		t.Error("new store is not empty")
+	}
+	if _, err := s.Load(dag.NewDAG()); err != store.ErrNoCheckpoint {
+		t.Errorf("got %v, want ErrNoCheckpoint", err)
+	}
+}
+
+func TestBlockStore_FollowFails(t *testing.T) {
+	s, err := store.OpenBlockStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newGhostDAG()
+	ts := time.Unix(1700000000, 0)
+	if err := d.AddGenesis(block.NewBlock(nil, nil, ts), block.UTXOSet{}); err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan error, 1)
+	stop := s.Follow(d, 0, func(err error) { failed <- err })
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := miner.New(d, miner.Config{Parents: miner.HeaviestTips{Max: 2}, Payout: "m"})
+	// with no buffer, each of these would wait on a follower that stopped
+	// reading
+	for i := 1; i <= 3; i++ {
+		blk, err := b.Build(ts.Add(time.Duration(i)*time.Second), []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.AddBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reported := <-failed
+	if reported == nil {
+		t.Fatal("no error reported")
+	}
+	if err := stop(); err != reported {
+		t.Fatalf("stop: %v, want %v", err, reported)
+	}
+}
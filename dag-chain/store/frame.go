@@ -0,0 +1,102 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// recordHeader is the length and CRC-32 of the payload that follows it.
+// Every file in this package is a sequence of such frames.
+const recordHeader = 8
+
+// ErrCorrupt is returned when a record inside the log fails its checksum.
+var ErrCorrupt = errors.New("store: corrupt record")
+
+// frame prefixes payload with its header.
+func frame(payload []byte) []byte {
+	rec := make([]byte, recordHeader, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(rec[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(payload))
+	return append(rec, payload...)
+}
+
+// readFrame reads one frame of at most max payload bytes. It returns
+// io.EOF at a clean end of input and ErrCorrupt for a short read, an
+// oversized length or a checksum mismatch: a torn write if at the end.
+func readFrame(r io.Reader, max uint32) ([]byte, error) {
+	var hdr [recordHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrCorrupt
+	}
+	size := binary.BigEndian.Uint32(hdr[:4])
+	if size > max {
+		return nil, ErrCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:]) {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
+
+// scanFrames calls fn with the offset and payload of each frame of f, of
+// at most max bytes, and returns the offset past the last good one. A bad
+// frame that runs to the end of the file is a torn write, as a crash
+// mid-append leaves, and ends the scan; the caller truncates it. A bad
+// frame followed by more data is ErrCorrupt, as dropping it would silently
+// drop everything after it too.
+func scanFrames(f *os.File, max uint32, fn func(off int64, payload []byte) error) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	r := bufio.NewReader(io.NewSectionReader(f, 0, end))
+	var off int64
+	for off < end {
+		var hdr [recordHeader]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return off, nil // torn header
+		}
+		size := binary.BigEndian.Uint32(hdr[:4])
+		next := off + recordHeader + int64(size)
+		bad := size > max
+		var payload []byte
+		if !bad {
+			payload = make([]byte, size)
+			_, err := io.ReadFull(r, payload)
+			bad = err != nil || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:])
+		}
+		if bad {
+			if next >= end {
+				return off, nil
+			}
+			return off, fmt.Errorf("%w at offset %d", ErrCorrupt, off)
+		}
+		if err := fn(off, payload); err != nil {
+			return off, err
+		}
+		off = next
+	}
+	return off, nil
+}
+
+// syncDir syncs a directory, so that a rename in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
@@ -0,0 +1,318 @@
+// Package store provides file-backed storage for dag-chain.
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+)
+
+// Record ops. opPut and opDelete are the history of the root store and are
+// replayed on open; opValue records only hold values written by snapshots
+// and are skipped on replay.
+const (
+	opPut byte = iota + 1
+	opDelete
+	opValue
+)
+
+// maxPayload bounds a UTXO record payload. Put refuses longer records, so
+// that every record it writes can be read back.
+const maxPayload = 1 << 16
+
+// ErrRecordTooLarge is returned by Put for an output whose record would
+// exceed the store's limit.
+var ErrRecordTooLarge = errors.New("store: record too large")
+
+// logFile is the append-only file shared by a LogStore and its snapshots.
+type logFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// LogStore is a block.UTXOStore backed by an append-only log. Values live
+// in the file; only an index from key to record offset is kept in memory.
+// The index is a block.UTXOTrie holding each offset as the output's Value,
+// so Snapshot is O(1) and a snapshot's index costs memory only for the keys
+// it writes.
+//
+// Snapshots share the log as a value heap. Only the store returned by
+// OpenLogStore is recovered when the file is reopened; OpenLogStore
+// compacts away the values of earlier snapshots and superseded records
+// once they make up most of the file.
+type LogStore struct {
+	log   *logFile
+	index *block.UTXOTrie
+	root  bool
+}
+
+var _ block.UTXOStore = (*LogStore)(nil)
+
+// OpenLogStore opens or creates the log at path and replays it. A torn
+// record at the end of the file, left by a crash mid-write, is truncated;
+// a bad record anywhere else is ErrCorrupt.
+func OpenLogStore(path string) (*LogStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &LogStore{
+		log:   &logFile{f: f},
+		index: block.NewUTXOTrie(),
+		root:  true,
+	}
+	records, err := s.replay()
+	if err == nil && records > 2*s.Len() {
+		err = s.compact(path)
+	}
+	if err != nil {
+		s.log.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay rebuilds the index from the root store's records and returns how
+// many records the log holds.
+func (s *LogStore) replay() (int, error) {
+	records := 0
+	end, err := scanFrames(s.log.f, maxPayload, func(off int64, payload []byte) error {
+		op, key, _, err := decodePayload(payload)
+		if err != nil {
+			return fmt.Errorf("%w at offset %d", err, off)
+		}
+		switch op {
+		case opPut:
+			s.index.Put(key, block.TXOutput{Value: uint64(off)})
+		case opDelete:
+			s.index.Delete(key)
+		}
+		records++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// torn tail: keep everything before it
+	if err := s.log.f.Truncate(end); err != nil {
+		return 0, err
+	}
+	s.log.size = end
+	return records, nil
+}
+
+// compact rewrites the log at path with one opPut record per entry and
+// swaps it in.
+func (s *LogStore) compact(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	index := block.NewUTXOTrie()
+	var size int64
+	var werr error
+	err = s.Iterate(func(key block.UTXOKey, out block.TXOutput) bool {
+		var rec []byte
+		if rec, werr = encodeRecord(opPut, key, out, maxPayload); werr == nil {
+			_, werr = w.Write(rec)
+		}
+		index.Put(key, block.TXOutput{Value: uint64(size)})
+		size += int64(len(rec))
+		return werr == nil
+	})
+	err = errors.Join(err, werr)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	s.log.f.Close()
+	s.log.f, s.log.size, s.index = f, size, index
+	return syncDir(filepath.Dir(path))
+}
+
+// Close closes the underlying file; snapshots become unusable.
+func (s *LogStore) Close() error {
+	return s.log.f.Close()
+}
+
+// Len returns the number of entries.
+func (s *LogStore) Len() int {
+	return s.index.Len()
+}
+
+func (s *LogStore) Get(key block.UTXOKey) (block.TXOutput, bool, error) {
+	ref, ok, _ := s.index.Get(key)
+	if !ok {
+		return block.TXOutput{}, false, nil
+	}
+	out, err := s.log.read(int64(ref.Value))
+	if err != nil {
+		return block.TXOutput{}, false, err
+	}
+	return out, true, nil
+}
+
+// Put appends a record for out. It fails with ErrRecordTooLarge, leaving
+// the store unchanged, if the record would exceed the limit.
+func (s *LogStore) Put(key block.UTXOKey, out block.TXOutput) error {
+	op := opValue
+	if s.root {
+		op = opPut
+	}
+	rec, err := encodeRecord(op, key, out, maxPayload)
+	if err != nil {
+		return err
+	}
+	off, err := s.log.append(rec)
+	if err != nil {
+		return err
+	}
+	s.index.Put(key, block.TXOutput{Value: uint64(off)})
+	return nil
+}
+
+func (s *LogStore) Delete(key block.UTXOKey) error {
+	if _, ok, _ := s.index.Get(key); !ok {
+		return nil
+	}
+	if s.root {
+		rec, err := encodeRecord(opDelete, key, block.TXOutput{}, maxPayload)
+		if err != nil {
+			return err
+		}
+		if _, err := s.log.append(rec); err != nil {
+			return err
+		}
+	}
+	return s.index.Delete(key)
+}
+
+func (s *LogStore) Iterate(fn func(block.UTXOKey, block.TXOutput) bool) error {
+	var err error
+	s.index.Iterate(func(key block.UTXOKey, ref block.TXOutput) bool {
+		var out block.TXOutput
+		if out, err = s.log.read(int64(ref.Value)); err != nil {
+			return false
+		}
+		return fn(key, out)
+	})
+	return err
+}
+
+// Snapshot shares the log and, until either store writes, the index.
+// Writes to the snapshot append value records that the root store
+// ignores.
+func (s *LogStore) Snapshot() (block.UTXOStore, error) {
+	index, err := s.index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &LogStore{log: s.log, index: index.(*block.UTXOTrie)}, nil
+}
+
+func (l *logFile) append(rec []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	off := l.size
+	if _, err := l.f.WriteAt(rec, off); err != nil {
+		return 0, err
+	}
+	l.size += int64(len(rec))
+	return off, nil
+}
+
+func (l *logFile) read(off int64) (block.TXOutput, error) {
+	var hdr [recordHeader]byte
+	if _, err := l.f.ReadAt(hdr[:], off); err != nil {
+		return block.TXOutput{}, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(hdr[:4]))
+	if _, err := l.f.ReadAt(payload, off+recordHeader); err != nil {
+		return block.TXOutput{}, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:]) {
+		return block.TXOutput{}, fmt.Errorf("%w at offset %d", ErrCorrupt, off)
+	}
+	_, _, out, err := decodePayload(payload)
+	return out, err
+}
+
+// encodeRecord frames op, key and out as header + payload, refusing a
+// payload over max bytes.
+func encodeRecord(op byte, key block.UTXOKey, out block.TXOutput, max int) ([]byte, error) {
+	payload := []byte{op}
+	payload = binary.AppendUvarint(payload, uint64(len(key.TxID)))
+	payload = append(payload, key.TxID...)
+	payload = binary.AppendUvarint(payload, uint64(key.OutIndex))
+	payload = binary.AppendUvarint(payload, out.Value)
+	payload = binary.AppendUvarint(payload, uint64(len(out.Recipient)))
+	payload = append(payload, out.Recipient...)
+	if len(payload) > max {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrRecordTooLarge, len(payload), max)
+	}
+	return frame(payload), nil
+}
+
+// readRecord reads one framed record of at most max payload bytes and
+// returns its size in bytes. Any short read or checksum mismatch is
+// reported as ErrCorrupt.
+func readRecord(r io.Reader, max uint32) (op byte, key block.UTXOKey, out block.TXOutput, n int64, err error) {
+	payload, err := readFrame(r, max)
+	if err != nil {
+		return 0, key, out, 0, err
+	}
+	op, key, out, err = decodePayload(payload)
+	return op, key, out, int64(recordHeader + len(payload)), err
+}
+
+func decodePayload(p []byte) (op byte, key block.UTXOKey, out block.TXOutput, err error) {
+	if len(p) == 0 {
+		return 0, key, out, ErrCorrupt
+	}
+	op, p = p[0], p[1:]
+	next := func() uint64 {
+		v, n := binary.Uvarint(p)
+		if n <= 0 {
+			err = ErrCorrupt
+			return 0
+		}
+		p = p[n:]
+		return v
+	}
+	str := func() string {
+		n := next()
+		if err != nil || n > uint64(len(p)) {
+			err = ErrCorrupt
+			return ""
+		}
+		s := string(p[:n])
+		p = p[n:]
+		return s
+	}
+	key.TxID = str()
+	key.OutIndex = int(next())
+	out.Value = next()
+	out.Recipient = str()
+	return op, key, out, err
+}
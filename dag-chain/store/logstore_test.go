@@ -0,0 +1,201 @@
+package store_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Abdullah-zahoor/dagchain/block"
+	"github.com/Abdullah-zahoor/dagchain/store"
+)
+
+func TestLogStore_PutGetDeleteReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := block.UTXOKey{TxID: "a", OutIndex: 0}
+	b := block.UTXOKey{TxID: "b", OutIndex: 1}
+	if err := s.Put(a, block.TXOutput{Value: 1, Recipient: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(b, block.TXOutput{Value: 2, Recipient: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(a); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok, err := s.Get(b); err != nil || !ok || out.Value != 2 || out.Recipient != "y" {
+		t.Fatalf("Get(b) = %+v, %v, %v", out, ok, err)
+	}
+	s.Close()
+
+	s, err = store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok, _ := s.Get(a); ok {
+		t.Error("deleted key came back after reopen")
+	}
+	if out, ok, _ := s.Get(b); !ok || out.Value != 2 {
+		t.Errorf("reopened Get(b) = %+v, %v", out, ok)
+	}
+}
+
+func TestLogStore_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := block.UTXOKey{TxID: "a", OutIndex: 0}
+	if err := s.Put(key, block.TXOutput{Value: 1, Recipient: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Simulate a crash in the middle of the next append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	s, err = store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("expected 1 entry after recovery, got %d", s.Len())
+	}
+	// Appends after recovery land on a clean boundary
+	other := block.UTXOKey{TxID: "b", OutIndex: 0}
+	if err := s.Put(other, block.TXOutput{Value: 3}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", s.Len())
+	}
+}
+
+func TestLogStore_SnapshotIsolation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	a := block.UTXOKey{TxID: "a", OutIndex: 0}
+	s.Put(a, block.TXOutput{Value: 1})
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Delete(a)
+	snap.Put(block.UTXOKey{TxID: "b", OutIndex: 0}, block.TXOutput{Value: 2})
+
+	if _, ok, _ := s.Get(a); !ok {
+		t.Error("snapshot delete leaked into root")
+	}
+	if s.Len() != 1 {
+		t.Errorf("snapshot put leaked into root: %d entries", s.Len())
+	}
+}
+
+func TestLogStore_RecordTooLarge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := block.TXOutput{Value: 1, Recipient: strings.Repeat("x", 1<<16)}
+	if err := s.Put(block.UTXOKey{TxID: "big"}, big); !errors.Is(err, store.ErrRecordTooLarge) {
+		t.Fatalf("oversized Put: got %v, want ErrRecordTooLarge", err)
+	}
+	if err := s.Put(block.UTXOKey{TxID: "a"}, block.TXOutput{Value: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 1 {
+		t.Errorf("expected 1 entry after reopen, got %d", s.Len())
+	}
+}
+
+func TestLogStore_CorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(block.UTXOKey{TxID: "a"}, block.TXOutput{Value: 1, Recipient: "x"})
+	s.Put(block.UTXOKey{TxID: "b"}, block.TXOutput{Value: 2, Recipient: "y"})
+	s.Close()
+
+	// Flip a bit in the first record; the second is intact after it
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 1
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.OpenLogStore(path); !errors.Is(err, store.ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Error("corrupt log was truncated")
+	}
+}
+
+func TestLogStore_CompactOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utxo.log")
+	s, err := store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := block.UTXOKey{TxID: "a"}
+	for i := 1; i <= 10; i++ {
+		s.Put(key, block.TXOutput{Value: uint64(i)})
+	}
+	snap, _ := s.Snapshot()
+	snap.Put(block.UTXOKey{TxID: "b"}, block.TXOutput{Value: 99})
+	s.Close()
+	before, _ := os.Stat(path)
+
+	s, err = store.OpenLogStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("log not compacted: %d bytes, was %d", after.Size(), before.Size())
+	}
+	if out, ok, _ := s.Get(key); !ok || out.Value != 10 || s.Len() != 1 {
+		t.Errorf("after compaction Get = %+v, %v with %d entries", out, ok, s.Len())
+	}
+	// the compacted log keeps taking appends
+	s.Put(block.UTXOKey{TxID: "c"}, block.TXOutput{Value: 3})
+	if out, ok, _ := s.Get(block.UTXOKey{TxID: "c"}); !ok || out.Value != 3 {
+		t.Errorf("Get after compaction = %+v, %v", out, ok)
+	}
+}
@@ -10,16 +10,17 @@ import (
 
 // ASCII returns a simple topological listing of each node and its parents.
 func ASCII(d *dag.DAG) string {
+	v := d.View()
 	// collect IDs and sort for deterministic output
-	ids := make([]string, 0, len(d.Nodes))
-	for id := range d.Nodes {
+	ids := make([]string, 0, v.Len())
+	for id := range v.Nodes() {
 		ids = append(ids, id)
 	}
 	sort.Strings(ids)
 
 	var buf bytes.Buffer
 	for _, id := range ids {
-		node := d.Nodes[id]
+		node := v.Node(id)
 		parentIDs := make([]string, len(node.Parents))
 		for i, p := range node.Parents {
 			parentIDs[i] = p.Block.ID
@@ -42,7 +43,7 @@ func DOT(d *dag.DAG) string {
 	buf.WriteString("  node [shape=box fontname=\"Monospace\"];\n")
 
 	// edges
-	for _, node := range d.Nodes {
+	for _, node := range d.View().Nodes() {
 		for _, p := range node.Parents {
 			buf.WriteString(fmt.Sprintf("  \"%s\" -> \"%s\";\n",
 				p.Block.ID, node.Block.ID))